@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/VladKvetkin/gophermart/internal/accrualsim"
+	"github.com/VladKvetkin/gophermart/internal/logger"
+	"go.uber.org/zap"
+)
+
+func main() {
+	os.Exit(start())
+}
+
+func start() int {
+	if err := logger.Initialize(); err != nil {
+		fmt.Fprintf(os.Stderr, "error create logger: %v\n", err)
+		return 1
+	}
+
+	config, err := accrualsim.NewConfig()
+	if err != nil {
+		zap.L().Info("error create config", zap.Error(err))
+		return 1
+	}
+
+	defer zap.L().Sync()
+
+	server := &http.Server{
+		Addr:              config.Address,
+		Handler:           accrualsim.NewHandler(config, accrualsim.NewSimulator(config.ProcessingDelay)).Routes(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := server.Shutdown(ctx); err != nil {
+			zap.L().Info("error stopping server", zap.Error(err))
+		}
+	}()
+
+	zap.L().Info("starting accrual simulator", zap.String("address", config.Address))
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		zap.L().Info("error starting server", zap.Error(err))
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/VladKvetkin/gophermart/internal/entities"
+	"github.com/VladKvetkin/gophermart/internal/services/password"
+	"github.com/VladKvetkin/gophermart/internal/storage"
+	"github.com/jmoiron/sqlx"
+)
+
+const createAdminUsage = "usage: gophermart create-admin [-d database_uri] -login login\n" +
+	"the password of a new user is read from ADMIN_PASSWORD or the first line of stdin"
+
+// createAdmin makes login an admin, registering it first when it does not
+// exist. Running it again for the same login changes nothing.
+func createAdmin(args []string) int {
+	flags := flag.NewFlagSet("create-admin", flag.ContinueOnError)
+	databaseURI := flags.String("d", os.Getenv("DATABASE_URI"), "Database URI")
+	login := flags.String("login", "", "Login of the admin")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() != 0 || *databaseURI == "" || *login == "" {
+		fmt.Fprintln(os.Stderr, createAdminUsage)
+		return 2
+	}
+
+	db, err := sqlx.Connect("postgres", *databaseURI)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error failed to connect to db: %v\n", err)
+		return 1
+	}
+
+	defer db.Close()
+
+	dataStorage, err := storage.NewPostgresStorage(db)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error failed to create postgres storage: %v\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+
+	user, err := dataStorage.GetUserByLogin(ctx, *login)
+	switch {
+	case err == nil:
+	case errors.Is(err, storage.ErrNoRows):
+		userID, err := registerAdmin(ctx, dataStorage, *login)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error failed to register %s: %v\n", *login, err)
+			return 1
+		}
+
+		fmt.Printf("registered %s\n", *login)
+
+		user = entities.User{ID: userID, Login: *login, Role: entities.RoleUser}
+	default:
+		fmt.Fprintf(os.Stderr, "error failed to get user: %v\n", err)
+		return 1
+	}
+
+	if user.Role == entities.RoleAdmin {
+		fmt.Printf("%s is already an admin\n", *login)
+		return 0
+	}
+
+	if err := dataStorage.SetUserRole(ctx, user.ID, entities.RoleAdmin); err != nil {
+		fmt.Fprintf(os.Stderr, "error failed to set role: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("%s is now an admin\n", *login)
+
+	return 0
+}
+
+func registerAdmin(ctx context.Context, dataStorage storage.Storage, login string) (string, error) {
+	plainPassword, err := readAdminPassword()
+	if err != nil {
+		return "", err
+	}
+
+	passwordHash, err := password.Hash(plainPassword)
+	if err != nil {
+		return "", err
+	}
+
+	return dataStorage.CreateUser(ctx, login, passwordHash)
+}
+
+// readAdminPassword keeps the password out of the command line, where other
+// users of the host could see it.
+func readAdminPassword() (string, error) {
+	if plainPassword := os.Getenv("ADMIN_PASSWORD"); plainPassword != "" {
+		return plainPassword, nil
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.New("no password in ADMIN_PASSWORD or on stdin")
+	}
+
+	plainPassword := strings.TrimRight(line, "\r\n")
+	if plainPassword == "" {
+		return "", errors.New("empty password")
+	}
+
+	return plainPassword, nil
+}
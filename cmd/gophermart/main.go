@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
@@ -10,18 +11,39 @@ import (
 
 	"github.com/VladKvetkin/gophermart/internal/accrualer"
 	"github.com/VladKvetkin/gophermart/internal/config"
+	"github.com/VladKvetkin/gophermart/internal/leader"
+	"github.com/VladKvetkin/gophermart/internal/logger"
 	"github.com/VladKvetkin/gophermart/internal/server"
+	"github.com/VladKvetkin/gophermart/internal/services/jwttoken"
+	"github.com/VladKvetkin/gophermart/internal/services/notifier"
 	"github.com/VladKvetkin/gophermart/internal/storage"
 	"github.com/jmoiron/sqlx"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 )
 
+// Held by the replica that runs the accrualer and other singleton jobs.
+const backgroundJobsLockKey = 7226410844
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			os.Exit(migrate(os.Args[2:]))
+		case "create-admin":
+			os.Exit(createAdmin(os.Args[2:]))
+		}
+	}
+
 	os.Exit(start())
 }
 
 func start() int {
+	if err := logger.Initialize(); err != nil {
+		fmt.Fprintf(os.Stderr, "error create logger: %v\n", err)
+		return 1
+	}
+
 	config, err := config.NewConfig()
 	if err != nil {
 		zap.L().Info("error create config", zap.Error(err))
@@ -30,28 +52,46 @@ func start() int {
 
 	defer zap.L().Sync()
 
-	db, err := sqlx.Connect("postgres", config.DatabaseURI)
-	if err != nil {
-		zap.L().Info("error failed to connect to db: %w", zap.Error(err))
-		return 1
-	}
+	var (
+		dataStorage storage.Storage
+		db          *sqlx.DB
+	)
+
+	if config.UseMemoryStorage() {
+		zap.L().Info("using in-memory storage, data will be lost on restart")
+
+		dataStorage = storage.NewMemoryStorage()
+	} else {
+		db, err = sqlx.Connect("postgres", config.DatabaseURI)
+		if err != nil {
+			zap.L().Info("error failed to connect to db: %w", zap.Error(err))
+			return 1
+		}
 
-	defer db.Close()
+		defer db.Close()
 
-	postgresStorage, err := storage.NewPostgresStorage(db)
+		dataStorage, err = storage.NewPostgresStorage(db)
+		if err != nil {
+			zap.L().Info("error failed to create postgres storage: %w", zap.Error(err))
+			return 1
+		}
+	}
+
+	tokens, err := jwttoken.NewManager(config)
 	if err != nil {
-		zap.L().Info("error failed to create postgres storage: %w", zap.Error(err))
+		zap.L().Info("error create token manager", zap.Error(err))
 		return 1
 	}
 
 	var (
 		accrualer = accrualer.NewAccrualer(
-			config.AccrualSystemAddress,
-			postgresStorage,
+			config,
+			dataStorage,
+			accrualer.NewHTTPClient(config.AccrualSystemAddress, config.AccrualRequestTimeout),
 		)
 	)
 
-	server := server.NewServer(config, postgresStorage)
+	server := server.NewServer(config, dataStorage, tokens, accrualer, accrualer, notifier.NewNotifier(config, zap.L()))
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer stop()
@@ -68,8 +108,19 @@ func start() int {
 	})
 
 	eg.Go(func() error {
-		if err := accrualer.Start(ctx); err != nil {
-			zap.L().Info("error starting accrualer", zap.Error(err))
+		var err error
+
+		// Background jobs run on a single replica, others wait on standby.
+		if config.UseMemoryStorage() {
+			err = runBackgroundJobs(ctx, config, accrualer)
+		} else {
+			err = leader.NewElector(db, backgroundJobsLockKey, config.LeaderElectionInterval).Run(ctx, func(ctx context.Context) error {
+				return runBackgroundJobs(ctx, config, accrualer)
+			})
+		}
+
+		if err != nil {
+			zap.L().Info("error running background jobs", zap.Error(err))
 			return err
 		}
 
@@ -92,3 +143,30 @@ func start() int {
 
 	return 0
 }
+
+func runBackgroundJobs(ctx context.Context, config config.Config, accrualer *accrualer.Accrualer) error {
+	eg, ctx := errgroup.WithContext(ctx)
+
+	eg.Go(func() error {
+		if err := accrualer.Start(ctx); err != nil {
+			zap.L().Info("error starting accrualer", zap.Error(err))
+			return err
+		}
+
+		return nil
+	})
+
+	// Orders uploaded to any replica wake the leader up.
+	if !config.UseMemoryStorage() {
+		eg.Go(func() error {
+			if err := storage.NewOrderListener(config.DatabaseURI).Listen(ctx, accrualer.Enqueue); err != nil {
+				zap.L().Info("error listening for new orders", zap.Error(err))
+				return err
+			}
+
+			return nil
+		})
+	}
+
+	return eg.Wait()
+}
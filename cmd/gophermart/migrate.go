@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/VladKvetkin/gophermart/internal/migrator"
+	"github.com/VladKvetkin/gophermart/internal/storage/migrations"
+	"github.com/jmoiron/sqlx"
+)
+
+const migrateUsage = "usage: gophermart migrate [-d database_uri] up|down|status"
+
+func migrate(args []string) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	databaseURI := flags.String("d", os.Getenv("DATABASE_URI"), "Database URI")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() != 1 || *databaseURI == "" {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	db, err := sqlx.Connect("postgres", *databaseURI)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error failed to connect to db: %v\n", err)
+		return 1
+	}
+
+	defer db.Close()
+
+	m, err := migrator.NewMigrator(db, migrations.FS)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error failed to load migrations: %v\n", err)
+		return 1
+	}
+
+	ctx := context.Background()
+
+	switch flags.Arg(0) {
+	case "up":
+		applied, err := m.Up(ctx)
+		for _, migration := range applied {
+			fmt.Printf("applied %04d_%s\n", migration.Version, migration.Name)
+		}
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+	case "down":
+		migration, err := m.Down(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		fmt.Printf("reverted %04d_%s\n", migration.Version, migration.Name)
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt.Valid {
+				appliedAt = status.AppliedAt.Time.Format(time.RFC3339)
+			}
+
+			fmt.Printf("%04d_%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	return 0
+}
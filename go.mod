@@ -6,11 +6,13 @@ require (
 	github.com/caarlos0/env/v8 v8.0.0
 	github.com/go-chi/chi v1.5.4
 	go.uber.org/zap v1.25.0
+	golang.org/x/crypto v0.14.0
 )
 
 require (
 	github.com/go-sql-driver/mysql v1.7.1 // indirect
 	golang.org/x/net v0.10.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
 )
 
 require (
@@ -2,40 +2,126 @@ package accrualer
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
-	"net/url"
-	"strconv"
+	"sync/atomic"
 	"time"
 
+	"github.com/VladKvetkin/gophermart/internal/config"
 	"github.com/VladKvetkin/gophermart/internal/entities"
-	"github.com/VladKvetkin/gophermart/internal/models"
-	"github.com/VladKvetkin/gophermart/internal/services/converter"
 	"github.com/VladKvetkin/gophermart/internal/storage"
-	"github.com/go-resty/resty/v2"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 )
 
 const (
-	getOrderPath = "/api/orders/"
+	// A lease must outlive the time a worker needs for a whole batch,
+	// otherwise another instance claims the rest of it again.
+	orderLease = 5 * time.Minute
+
+	// Orders enqueued beyond this are dropped and left to the periodic sweep.
+	enqueuedOrdersLimit = 1024
+
+	// Leases are released even after the context is done, within this time.
+	releaseTimeout = 5 * time.Second
+
+	// The published breaker state is trusted for this many poll intervals.
+	breakerStateMaxAgeIntervals = 3
 )
 
+var errOrderNotRegistered = errors.New("order is not registered in accrual system")
+
 type Accrualer struct {
-	apiAddress string
-	storage    storage.Storage
+	config  config.Config
+	storage storage.Storage
+	client  AccrualClient
+	limiter *rateLimiter
+	breaker *circuitBreaker
+	orders  chan string
+	running atomic.Bool
 }
 
-func NewAccrualer(apiAddress string, storage storage.Storage) *Accrualer {
+func NewAccrualer(config config.Config, storage storage.Storage, client AccrualClient) *Accrualer {
 	return &Accrualer{
-		apiAddress: apiAddress,
-		storage:    storage,
+		config:  config,
+		storage: storage,
+		client:  client,
+		limiter: newRateLimiter(),
+		breaker: newCircuitBreaker(config.AccrualBreakerFailures, config.AccrualBreakerOpenTimeout),
+		orders:  make(chan string, enqueuedOrdersLimit),
 	}
 }
 
 func (ac *Accrualer) Start(ctx context.Context) error {
-	ticker := time.NewTicker(3 * time.Second)
+	ac.running.Store(true)
+	defer ac.running.Store(false)
+
+	eg, ctx := errgroup.WithContext(ctx)
+
+	eg.Go(func() error {
+		return ac.processEnqueuedOrders(ctx)
+	})
+
+	eg.Go(func() error {
+		return ac.sweep(ctx)
+	})
+
+	eg.Go(func() error {
+		return ac.publishBreakerStates(ctx)
+	})
+
+	return eg.Wait()
+}
+
+// Enqueue asks for the order to be checked right away instead of waiting for
+// the next sweep. It never blocks and does nothing unless the accrualer runs
+// on this instance.
+func (ac *Accrualer) Enqueue(number string) {
+	if !ac.running.Load() {
+		return
+	}
+
+	select {
+	case ac.orders <- number:
+	default:
+		zap.L().Info("accrual queue is full, leaving order to the sweep", zap.String("number", number))
+	}
+}
+
+func (ac *Accrualer) processEnqueuedOrders(ctx context.Context) error {
+	for {
+		select {
+		case number := <-ac.orders:
+			if ac.breaker.State() == BreakerOpen {
+				continue
+			}
+
+			order, ok, err := ac.storage.ClaimOrderForAccrualer(ctx, number, orderLease)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+
+				zap.L().Info("error failed to claim enqueued order %w", zap.Error(err))
+				continue
+			}
+
+			// Another worker or instance has already claimed the order.
+			if !ok {
+				continue
+			}
+
+			if !ac.processOrder(ctx, order) {
+				ac.releaseOrders([]entities.Order{order})
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (ac *Accrualer) sweep(ctx context.Context) error {
+	ticker := time.NewTicker(ac.config.AccrualPollInterval)
 	defer ticker.Stop()
 
 	if err := ac.selectAndUpdateOrders(ctx); err != nil {
@@ -56,17 +142,23 @@ func (ac *Accrualer) Start(ctx context.Context) error {
 
 func (ac *Accrualer) selectAndUpdateOrders(ctx context.Context) error {
 	var (
-		ordersLimit   = 1000
+		ordersLimit   = 100
 		workersNumber = 10
 	)
 
+	switch ac.breaker.State() {
+	case BreakerOpen:
+		zap.L().Info("accrual system circuit breaker is open, skipping accrual check")
+		return nil
+	case BreakerHalfOpen:
+		ordersLimit, workersNumber = 1, 1
+	}
+
 	eg, ctx := errgroup.WithContext(ctx)
-	client := ac.initClient()
 
 	for i := 0; i < workersNumber; i++ {
-		i := i
 		eg.Go(func() error {
-			orders, err := ac.storage.GetOrdersForAccrualer(ctx, ordersLimit*i, ordersLimit)
+			orders, err := ac.storage.ClaimOrdersForAccrualer(ctx, ordersLimit, orderLease)
 			if err != nil {
 				return err
 			}
@@ -75,29 +167,11 @@ func (ac *Accrualer) selectAndUpdateOrders(ctx context.Context) error {
 				return nil
 			}
 
-			retryAfter := 0
-			for _, order := range orders {
-				response, retryAfter, err := ac.checkOrderAccrual(client, order)
-				if err != nil {
-					zap.L().Info("error failed to check order accrual %w", zap.Error(err))
-
-					continue
-				}
-
-				if retryAfter > 0 {
+			for i, order := range orders {
+				if !ac.processOrder(ctx, order) {
+					ac.releaseOrders(orders[i:])
 					break
 				}
-
-				err = ac.updateOrder(ctx, order, response)
-				if err != nil {
-					zap.L().Info("error failed to update order accrual %w", zap.Error(err))
-
-					continue
-				}
-			}
-
-			if retryAfter > 0 {
-				time.Sleep(time.Duration(retryAfter) * time.Second)
 			}
 
 			return nil
@@ -111,63 +185,147 @@ func (ac *Accrualer) selectAndUpdateOrders(ctx context.Context) error {
 	return nil
 }
 
-func (ac *Accrualer) updateOrder(ctx context.Context, order entities.Order, updateFields models.AccrualAPIGetOrderResponse) error {
-	return ac.storage.UpdateOrder(ctx, order, converter.ConvertAccrual(updateFields.Accrual), updateFields.Status)
-}
+// processOrder reports whether the worker may go on with its batch, which
+// it may not once the context is done or the circuit breaker is open. The
+// order is left untouched then.
+func (ac *Accrualer) processOrder(ctx context.Context, order entities.Order) bool {
+	for {
+		if err := ac.limiter.Wait(ctx); err != nil {
+			return false
+		}
+
+		if !ac.breaker.Allow() {
+			return false
+		}
+
+		result, err := ac.client.GetOrderAccrual(ctx, order.Number)
+		if err != nil {
+			if ctx.Err() != nil {
+				return false
+			}
+
+			ac.breaker.Failure()
+
+			zap.L().Info("error failed to check order accrual %w", zap.Error(err))
 
-func (ac *Accrualer) initClient() *resty.Client {
-	return resty.New()
+			// An unavailable accrual system says nothing about the order,
+			// the check is repeated without using up an attempt.
+			if err := ac.storage.PostponeOrder(ctx, order, err.Error(), ac.retryDelay(order.Attempts+1)); err != nil {
+				zap.L().Info("error failed to schedule order accrual retry %w", zap.Error(err))
+			}
+
+			return true
+		}
+
+		ac.breaker.Success()
+
+		switch result.Kind {
+		case ResultRateLimited:
+			zap.L().Info(
+				"accrual system rate limit exceeded, pausing requests",
+				zap.Duration("retryAfter", result.RetryAfter),
+				zap.Int("perMinute", result.RequestsPerMinute),
+			)
+
+			ac.limiter.Throttle(result.RetryAfter, result.RequestsPerMinute)
+			continue
+		case ResultNotFound:
+			if err := ac.retryOrder(ctx, order, errOrderNotRegistered); err != nil {
+				zap.L().Info("error failed to schedule order accrual retry %w", zap.Error(err))
+			}
+		default:
+			ac.limiter.Success()
+
+			if err := ac.updateOrder(ctx, order, result); err != nil {
+				zap.L().Info("error failed to update order accrual %w", zap.Error(err))
+			}
+		}
+
+		return true
+	}
 }
 
-func (ac *Accrualer) checkOrderAccrual(client *resty.Client, order entities.Order) (models.AccrualAPIGetOrderResponse, int, error) {
-	url, err := ac.getOrderPath(order.Number)
-	if err != nil {
-		return models.AccrualAPIGetOrderResponse{}, 0, err
+// releaseOrders lets any instance claim orders this one will not process
+// before their leases expire.
+func (ac *Accrualer) releaseOrders(orders []entities.Order) {
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+
+	if err := ac.storage.ReleaseOrders(ctx, orders); err != nil {
+		zap.L().Info("error release order leases", zap.Error(err))
 	}
+}
 
-	request := client.R().SetDoNotParseResponse(true)
+// BreakerState returns the state of the circuit breaker of the replica that
+// runs the accrualer, which publishes it to storage on every sweep.
+func (ac *Accrualer) BreakerState(ctx context.Context) string {
+	if ac.running.Load() {
+		return ac.breaker.State()
+	}
 
-	response, err := request.Get(url)
+	state, err := ac.storage.GetAccrualBreakerState(ctx, breakerStateMaxAgeIntervals*ac.config.AccrualPollInterval)
 	if err != nil {
-		return models.AccrualAPIGetOrderResponse{}, 0, err
+		if !errors.Is(err, storage.ErrNoRows) {
+			zap.L().Info("error get accrual breaker state", zap.Error(err))
+		}
+
+		return BreakerUnknown
 	}
 
-	defer response.RawBody().Close()
+	return state
+}
 
-	if response.StatusCode() == http.StatusNoContent {
-		return models.AccrualAPIGetOrderResponse{
-			Number: order.Number,
-			Status: entities.OrderStatusNew,
-		}, 0, nil
-	} else if response.StatusCode() == http.StatusTooManyRequests {
-		retryAfter, err := strconv.Atoi(response.Header().Get("Retry-After"))
-		if err != nil {
-			return models.AccrualAPIGetOrderResponse{}, 0, fmt.Errorf("error failed to parse Retry-After value, err: %w", err)
-		}
+// publishBreakerStates publishes the breaker state on every poll interval,
+// however long a sweep takes, and right after every change of the state.
+func (ac *Accrualer) publishBreakerStates(ctx context.Context) error {
+	ticker := time.NewTicker(ac.config.AccrualPollInterval)
+	defer ticker.Stop()
 
-		return models.AccrualAPIGetOrderResponse{}, retryAfter, nil
+	for {
+		ac.publishBreakerState(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ac.breaker.changes:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
 	}
+}
 
-	if response.StatusCode() != http.StatusOK {
-		return models.AccrualAPIGetOrderResponse{}, 0, fmt.Errorf("error failed to get order accrual, invalid status: %v", response.Status())
+func (ac *Accrualer) publishBreakerState(ctx context.Context) {
+	if err := ac.storage.SaveAccrualBreakerState(ctx, ac.breaker.State()); err != nil {
+		zap.L().Info("error save accrual breaker state", zap.Error(err))
 	}
+}
 
-	responseOrderAccrual := models.AccrualAPIGetOrderResponse{}
+func (ac *Accrualer) updateOrder(ctx context.Context, order entities.Order, result AccrualResult) error {
+	status, err := entities.ParseAccrualStatus(string(result.Kind))
+	if err != nil {
+		return err
+	}
 
-	jsonDecoder := json.NewDecoder(response.RawBody())
+	return ac.storage.UpdateOrder(ctx, order, result.Accrual.Cents(), status)
+}
 
-	if err := jsonDecoder.Decode(&responseOrderAccrual); err != nil {
-		return models.AccrualAPIGetOrderResponse{}, 0, fmt.Errorf("cannot decode response get order accrual to json: %w", err)
+func (ac *Accrualer) retryOrder(ctx context.Context, order entities.Order, checkErr error) error {
+	attempts := order.Attempts + 1
+	if attempts >= ac.config.AccrualMaxAttempts {
+		return ac.storage.InvalidateOrder(ctx, order, fmt.Sprintf("gave up after %d attempts: %v", attempts, checkErr))
 	}
 
-	return responseOrderAccrual, 0, nil
+	return ac.storage.RetryOrder(ctx, order, checkErr.Error(), ac.retryDelay(attempts))
 }
 
-func (ac *Accrualer) getOrderPath(orderNumber string) (string, error) {
-	url, err := url.JoinPath(ac.apiAddress, getOrderPath, orderNumber)
-	if err != nil {
-		return "", err
+func (ac *Accrualer) retryDelay(attempts int) time.Duration {
+	delay := ac.config.AccrualRetryBase
+	for i := 1; i < attempts && delay < ac.config.AccrualRetryMax; i++ {
+		delay *= 2
+	}
+
+	if delay > ac.config.AccrualRetryMax {
+		return ac.config.AccrualRetryMax
 	}
 
-	return url, nil
+	return delay
 }
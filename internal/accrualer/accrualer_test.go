@@ -0,0 +1,333 @@
+package accrualer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/VladKvetkin/gophermart/internal/config"
+	"github.com/VladKvetkin/gophermart/internal/entities"
+	"github.com/VladKvetkin/gophermart/internal/money"
+	"github.com/VladKvetkin/gophermart/internal/storage"
+)
+
+const waitTimeout = 5 * time.Second
+
+var errUnavailable = errors.New("accrual system is unavailable")
+
+func testConfig() config.Config {
+	return config.Config{
+		AccrualPollInterval:       10 * time.Millisecond,
+		AccrualMaxAttempts:        20,
+		AccrualRetryBase:          10 * time.Millisecond,
+		AccrualRetryMax:           50 * time.Millisecond,
+		AccrualBreakerFailures:    100,
+		AccrualBreakerOpenTimeout: time.Hour,
+	}
+}
+
+func TestAccrualerProcessesOrders(t *testing.T) {
+	s, userID := newStorage(t)
+	client := NewFakeClient()
+
+	createOrder(t, s, userID, "12345678903")
+	createOrder(t, s, userID, "4561261212345467")
+
+	client.SetResult(AccrualResult{Kind: ResultProcessed, Number: "12345678903", Accrual: money.FromCents(500_00)})
+	client.SetResult(AccrualResult{Kind: ResultInvalid, Number: "4561261212345467"})
+
+	start(t, NewAccrualer(testConfig(), s, client))
+
+	waitOrderStatus(t, s, "12345678903", entities.OrderStatusProcessed)
+	waitOrderStatus(t, s, "4561261212345467", entities.OrderStatusInvalid)
+
+	if accrual, err := s.GetUserAccrual(context.Background(), userID); err != nil || accrual != 500_00 {
+		t.Fatalf("GetUserAccrual: got %d, %v, want %d", accrual, err, 500_00)
+	}
+}
+
+func TestAccrualerChecksProcessingOrdersAgain(t *testing.T) {
+	s, userID := newStorage(t)
+	client := NewFakeClient()
+
+	createOrder(t, s, userID, "12345678903")
+	client.SetResult(AccrualResult{Kind: ResultProcessing, Number: "12345678903"})
+
+	start(t, NewAccrualer(testConfig(), s, client))
+
+	waitOrderStatus(t, s, "12345678903", entities.OrderStatusProcessing)
+	waitFor(t, "a second check of a processing order", func() bool { return client.Calls("12345678903") > 1 })
+
+	client.SetResult(AccrualResult{Kind: ResultProcessed, Number: "12345678903", Accrual: money.FromCents(1)})
+
+	waitOrderStatus(t, s, "12345678903", entities.OrderStatusProcessed)
+}
+
+// TestAccrualerBacksOff checks that failed checks are retried on the backoff
+// schedule instead of on every sweep, and that the order is processed once
+// the accrual system answers again.
+func TestAccrualerBacksOff(t *testing.T) {
+	config := testConfig()
+	config.AccrualRetryBase = 200 * time.Millisecond
+	config.AccrualRetryMax = 200 * time.Millisecond
+
+	s, userID := newStorage(t)
+	client := NewFakeClient()
+
+	createOrder(t, s, userID, "12345678903")
+	client.SetError("12345678903", errUnavailable)
+
+	start(t, NewAccrualer(config, s, client))
+
+	waitFor(t, "the first check", func() bool { return client.Calls("12345678903") == 1 })
+
+	// Twenty sweeps pass before the retry is due.
+	time.Sleep(100 * time.Millisecond)
+
+	if calls := client.Calls("12345678903"); calls != 1 {
+		t.Fatalf("checks before the retry is due: got %d, want 1", calls)
+	}
+
+	order := getOrder(t, s, "12345678903")
+	if order.Attempts != 0 || order.LastError.String != errUnavailable.Error() {
+		t.Fatalf("order after a failed check: got %+v, want the error without an attempt used up", order)
+	}
+
+	client.SetResult(AccrualResult{Kind: ResultProcessed, Number: "12345678903", Accrual: money.FromCents(1)})
+
+	waitOrderStatus(t, s, "12345678903", entities.OrderStatusProcessed)
+}
+
+func TestAccrualerGivesUp(t *testing.T) {
+	config := testConfig()
+	config.AccrualMaxAttempts = 3
+
+	s, userID := newStorage(t)
+	client := NewFakeClient()
+
+	createOrder(t, s, userID, "12345678903")
+
+	start(t, NewAccrualer(config, s, client))
+
+	waitOrderStatus(t, s, "12345678903", entities.OrderStatusInvalid)
+
+	if calls := client.Calls("12345678903"); calls != config.AccrualMaxAttempts {
+		t.Fatalf("checks of an order unknown to the accrual system: got %d, want %d", calls, config.AccrualMaxAttempts)
+	}
+}
+
+// TestAccrualerOutlivesOutage checks that an accrual system failing for
+// longer than the attempts allow does not give up on the order.
+func TestAccrualerOutlivesOutage(t *testing.T) {
+	config := testConfig()
+	config.AccrualMaxAttempts = 2
+
+	s, userID := newStorage(t)
+	client := NewFakeClient()
+
+	createOrder(t, s, userID, "12345678903")
+	client.SetError("12345678903", errUnavailable)
+
+	start(t, NewAccrualer(config, s, client))
+
+	waitFor(t, "checks beyond the attempts", func() bool { return client.Calls("12345678903") > 2*config.AccrualMaxAttempts })
+
+	if order := getOrder(t, s, "12345678903"); order.Status == entities.OrderStatusInvalid || order.Attempts != 0 {
+		t.Fatalf("order during an outage: got %+v, want it still checked without attempts used up", order)
+	}
+
+	client.SetResult(AccrualResult{Kind: ResultProcessed, Number: "12345678903", Accrual: money.FromCents(1)})
+
+	waitOrderStatus(t, s, "12345678903", entities.OrderStatusProcessed)
+}
+
+func TestAccrualerOpensBreaker(t *testing.T) {
+	config := testConfig()
+	config.AccrualBreakerFailures = 2
+
+	s, userID := newStorage(t)
+	client := NewFakeClient()
+
+	numbers := []string{"12345678903", "4561261212345467", "79927398713"}
+	for _, number := range numbers {
+		createOrder(t, s, userID, number)
+		client.SetError(number, errUnavailable)
+	}
+
+	accrualer := NewAccrualer(config, s, client)
+	start(t, accrualer)
+
+	waitFor(t, "the breaker to open", func() bool { return accrualer.BreakerState(context.Background()) == BreakerOpen })
+
+	standby := NewAccrualer(config, s, nil)
+	waitFor(t, "a standby to see the breaker open", func() bool { return standby.BreakerState(context.Background()) == BreakerOpen })
+
+	time.Sleep(100 * time.Millisecond)
+
+	calls := 0
+	for _, number := range numbers {
+		calls += client.Calls(number)
+	}
+
+	if calls != config.AccrualBreakerFailures {
+		t.Fatalf("checks with an open breaker: got %d, want %d", calls, config.AccrualBreakerFailures)
+	}
+
+	// Orders left in the batch when the breaker opened are not kept leased.
+	for _, number := range numbers {
+		if order := getOrder(t, s, number); order.LeaseExpiresAt.Valid {
+			t.Fatalf("order %s with an open breaker: got a lease until %v, want none", number, order.LeaseExpiresAt.Time)
+		}
+	}
+}
+
+// blockingClient keeps every request hanging until the test ends.
+type blockingClient struct {
+	done chan struct{}
+}
+
+func (c blockingClient) GetOrderAccrual(ctx context.Context, number string) (AccrualResult, error) {
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+	}
+
+	return AccrualResult{}, errUnavailable
+}
+
+// TestAccrualerPublishesBreakerState checks that a replica without the
+// accrualer keeps seeing the breaker state while a sweep hangs for far
+// longer than the state is trusted.
+func TestAccrualerPublishesBreakerState(t *testing.T) {
+	s, userID := newStorage(t)
+	createOrder(t, s, userID, "12345678903")
+
+	config := testConfig()
+	client := blockingClient{done: make(chan struct{})}
+	t.Cleanup(func() { close(client.done) })
+
+	start(t, NewAccrualer(config, s, client))
+	standby := NewAccrualer(config, s, nil)
+
+	time.Sleep(10 * breakerStateMaxAgeIntervals * config.AccrualPollInterval)
+
+	if state := standby.BreakerState(context.Background()); state != BreakerClosed {
+		t.Fatalf("breaker state on a standby during a long sweep: got %q, want %q", state, BreakerClosed)
+	}
+}
+
+func TestAccrualerEnqueue(t *testing.T) {
+	config := testConfig()
+	config.AccrualPollInterval = time.Hour
+
+	s, userID := newStorage(t)
+	client := NewFakeClient()
+
+	accrualer := NewAccrualer(config, s, client)
+
+	// Enqueued before start, the order is left to the sweep.
+	createOrder(t, s, userID, "79927398713")
+	accrualer.Enqueue("79927398713")
+
+	start(t, accrualer)
+	waitFor(t, "the first sweep", func() bool { return client.Calls("79927398713") == 1 })
+
+	createOrder(t, s, userID, "12345678903")
+	client.SetResult(AccrualResult{Kind: ResultProcessed, Number: "12345678903", Accrual: money.FromCents(1)})
+	accrualer.Enqueue("12345678903")
+
+	waitOrderStatus(t, s, "12345678903", entities.OrderStatusProcessed)
+}
+
+func TestRetryDelay(t *testing.T) {
+	config := testConfig()
+	config.AccrualRetryBase = time.Second
+	config.AccrualRetryMax = 10 * time.Second
+
+	accrualer := NewAccrualer(config, nil, nil)
+
+	for attempts, want := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		4:  8 * time.Second,
+		5:  10 * time.Second,
+		50: 10 * time.Second,
+	} {
+		if got := accrualer.retryDelay(attempts); got != want {
+			t.Errorf("retryDelay(%d): got %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+func newStorage(t *testing.T) (storage.Storage, string) {
+	t.Helper()
+
+	s := storage.NewMemoryStorage()
+
+	userID, err := s.CreateUser(context.Background(), "user", "hash")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	return s, userID
+}
+
+func createOrder(t *testing.T, s storage.Storage, userID string, number string) {
+	t.Helper()
+
+	if _, err := s.CreateOrder(context.Background(), userID, number); err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+}
+
+func getOrder(t *testing.T, s storage.Storage, number string) entities.Order {
+	t.Helper()
+
+	order, err := s.GetOrderByNumber(context.Background(), number)
+	if err != nil {
+		t.Fatalf("GetOrderByNumber: %v", err)
+	}
+
+	return order
+}
+
+// start runs the accrualer until the test ends.
+func start(t *testing.T, accrualer *Accrualer) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		accrualer.Start(ctx)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	waitFor(t, "the accrualer to start", accrualer.running.Load)
+}
+
+func waitOrderStatus(t *testing.T, s storage.Storage, number string, status string) {
+	t.Helper()
+
+	waitFor(t, "order "+number+" to become "+status, func() bool { return getOrder(t, s, number).Status == status })
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(waitTimeout)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
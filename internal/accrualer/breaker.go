@@ -0,0 +1,123 @@
+package accrualer
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+
+	// BreakerUnknown is reported when no replica has published its state
+	// recently, usually because no replica runs the accrualer.
+	BreakerUnknown = "unknown"
+)
+
+// circuitBreaker stops calls to the accrual system after failureThreshold
+// consecutive failures. Once openTimeout has passed a single probe is let
+// through: its success closes the circuit, its failure opens it again.
+type circuitBreaker struct {
+	mu sync.Mutex
+
+	failureThreshold int
+	openTimeout      time.Duration
+
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+
+	// Receives a value whenever the state changes, changes not received yet
+	// are merged into one.
+	changes chan struct{}
+}
+
+func newCircuitBreaker(failureThreshold int, openTimeout time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		failureThreshold: failureThreshold,
+		openTimeout:      openTimeout,
+		state:            BreakerClosed,
+		changes:          make(chan struct{}, 1),
+	}
+}
+
+func (b *circuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.halfOpenIfExpired()
+
+	return b.state
+}
+
+func (b *circuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.halfOpenIfExpired()
+
+	switch b.state {
+	case BreakerClosed:
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+
+		b.probing = true
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *circuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.probing = false
+
+	if b.state != BreakerClosed {
+		b.setState(BreakerClosed)
+	}
+}
+
+func (b *circuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.failureThreshold) {
+		b.openedAt = time.Now()
+		b.setState(BreakerOpen)
+	}
+}
+
+func (b *circuitBreaker) halfOpenIfExpired() {
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.openTimeout {
+		b.setState(BreakerHalfOpen)
+	}
+}
+
+func (b *circuitBreaker) setState(state string) {
+	zap.L().Info(
+		"accrual system circuit breaker state changed",
+		zap.String("from", b.state),
+		zap.String("to", state),
+		zap.Int("failures", b.failures),
+	)
+
+	b.state = state
+
+	select {
+	case b.changes <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,136 @@
+package accrualer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/VladKvetkin/gophermart/internal/models"
+	"github.com/VladKvetkin/gophermart/internal/money"
+	"github.com/go-resty/resty/v2"
+)
+
+const getOrderPath = "/api/orders/"
+
+type ResultKind string
+
+const (
+	ResultRegistered  ResultKind = "REGISTERED"
+	ResultProcessing  ResultKind = "PROCESSING"
+	ResultProcessed   ResultKind = "PROCESSED"
+	ResultInvalid     ResultKind = "INVALID"
+	ResultNotFound    ResultKind = "NOT_FOUND"
+	ResultRateLimited ResultKind = "RATE_LIMITED"
+)
+
+var rateLimitBodyRe = regexp.MustCompile(`No more than (\d+) requests per minute allowed`)
+
+type AccrualResult struct {
+	Kind    ResultKind
+	Number  string
+	Accrual money.Amount
+
+	// Set for ResultRateLimited only, RequestsPerMinute is 0 when the
+	// accrual system did not tell its limit.
+	RetryAfter        time.Duration
+	RequestsPerMinute int
+}
+
+// AccrualClient returns an error only when the accrual system could not
+// give an answer, e.g. it is unreachable or responded with 5xx.
+type AccrualClient interface {
+	GetOrderAccrual(ctx context.Context, number string) (AccrualResult, error)
+}
+
+type HTTPClient struct {
+	address string
+	client  *resty.Client
+}
+
+func NewHTTPClient(address string, timeout time.Duration) *HTTPClient {
+	return &HTTPClient{
+		address: address,
+		client:  resty.New().SetTimeout(timeout),
+	}
+}
+
+func (c *HTTPClient) GetOrderAccrual(ctx context.Context, number string) (AccrualResult, error) {
+	url, err := url.JoinPath(c.address, getOrderPath, number)
+	if err != nil {
+		return AccrualResult{}, err
+	}
+
+	request := c.client.R().SetContext(ctx).SetDoNotParseResponse(true)
+
+	response, err := request.Get(url)
+	if err != nil {
+		return AccrualResult{}, err
+	}
+
+	defer response.RawBody().Close()
+
+	switch response.StatusCode() {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return AccrualResult{Kind: ResultNotFound, Number: number}, nil
+	case http.StatusTooManyRequests:
+		return parseRateLimit(number, response)
+	default:
+		return AccrualResult{}, fmt.Errorf("error failed to get order accrual, invalid status: %v", response.Status())
+	}
+
+	responseOrderAccrual := models.AccrualAPIGetOrderResponse{}
+
+	jsonDecoder := json.NewDecoder(response.RawBody())
+
+	if err := jsonDecoder.Decode(&responseOrderAccrual); err != nil {
+		return AccrualResult{}, fmt.Errorf("cannot decode response get order accrual to json: %w", err)
+	}
+
+	result := AccrualResult{
+		Kind:    ResultKind(responseOrderAccrual.Status),
+		Number:  responseOrderAccrual.Number,
+		Accrual: responseOrderAccrual.Accrual,
+	}
+
+	switch result.Kind {
+	case ResultRegistered, ResultProcessing, ResultProcessed, ResultInvalid:
+		return result, nil
+	default:
+		return AccrualResult{}, fmt.Errorf("unknown order accrual status %q", responseOrderAccrual.Status)
+	}
+}
+
+func parseRateLimit(number string, response *resty.Response) (AccrualResult, error) {
+	retryAfter, err := strconv.Atoi(response.Header().Get("Retry-After"))
+	if err != nil {
+		return AccrualResult{}, fmt.Errorf("error failed to parse Retry-After value, err: %w", err)
+	}
+
+	if retryAfter < 0 {
+		return AccrualResult{}, fmt.Errorf("error negative Retry-After value %d", retryAfter)
+	}
+
+	result := AccrualResult{
+		Kind:       ResultRateLimited,
+		Number:     number,
+		RetryAfter: time.Duration(retryAfter) * time.Second,
+	}
+
+	body, err := io.ReadAll(response.RawBody())
+	if err != nil {
+		return AccrualResult{}, err
+	}
+
+	if matches := rateLimitBodyRe.FindSubmatch(body); matches != nil {
+		result.RequestsPerMinute, _ = strconv.Atoi(string(matches[1]))
+	}
+
+	return result, nil
+}
@@ -0,0 +1,144 @@
+package accrualer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/VladKvetkin/gophermart/internal/money"
+)
+
+func TestHTTPClient(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		body       string
+		want       AccrualResult
+		wantErr    bool
+	}{
+		{
+			name:   "Processed",
+			status: http.StatusOK,
+			body:   `{"order": "12345678903", "status": "PROCESSED", "accrual": 729.98}`,
+			want:   AccrualResult{Kind: ResultProcessed, Number: "12345678903", Accrual: money.FromCents(729_98)},
+		},
+		{
+			name:   "Processing",
+			status: http.StatusOK,
+			body:   `{"order": "12345678903", "status": "PROCESSING"}`,
+			want:   AccrualResult{Kind: ResultProcessing, Number: "12345678903"},
+		},
+		{
+			name:    "UnknownStatus",
+			status:  http.StatusOK,
+			body:    `{"order": "12345678903", "status": "DONE"}`,
+			wantErr: true,
+		},
+		{
+			name:    "MalformedBody",
+			status:  http.StatusOK,
+			body:    `{"order": `,
+			wantErr: true,
+		},
+		{
+			name:   "NotRegistered",
+			status: http.StatusNoContent,
+			want:   AccrualResult{Kind: ResultNotFound, Number: "12345678903"},
+		},
+		{
+			name:       "RateLimited",
+			status:     http.StatusTooManyRequests,
+			retryAfter: "60",
+			body:       "No more than 100 requests per minute allowed",
+			want:       AccrualResult{Kind: ResultRateLimited, Number: "12345678903", RetryAfter: time.Minute, RequestsPerMinute: 100},
+		},
+		{
+			name:       "RateLimitedWithoutLimit",
+			status:     http.StatusTooManyRequests,
+			retryAfter: "5",
+			body:       "Too many requests",
+			want:       AccrualResult{Kind: ResultRateLimited, Number: "12345678903", RetryAfter: 5 * time.Second},
+		},
+		{
+			name:    "RateLimitedWithoutRetryAfter",
+			status:  http.StatusTooManyRequests,
+			wantErr: true,
+		},
+		{
+			name:       "RateLimitedWithDateRetryAfter",
+			status:     http.StatusTooManyRequests,
+			retryAfter: "Wed, 21 Oct 2015 07:28:00 GMT",
+			wantErr:    true,
+		},
+		{
+			name:       "RateLimitedWithFractionalRetryAfter",
+			status:     http.StatusTooManyRequests,
+			retryAfter: "1.5",
+			wantErr:    true,
+		},
+		{
+			name:       "RateLimitedWithNegativeRetryAfter",
+			status:     http.StatusTooManyRequests,
+			retryAfter: "-1",
+			wantErr:    true,
+		},
+		{
+			name:    "InternalServerError",
+			status:  http.StatusInternalServerError,
+			wantErr: true,
+		},
+		{
+			name:    "BadGateway",
+			status:  http.StatusBadGateway,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				if req.URL.Path != getOrderPath+"12345678903" {
+					t.Errorf("request path: got %q, want %q", req.URL.Path, getOrderPath+"12345678903")
+				}
+
+				if tt.retryAfter != "" {
+					res.Header().Set("Retry-After", tt.retryAfter)
+				}
+
+				res.WriteHeader(tt.status)
+				res.Write([]byte(tt.body))
+			}))
+			t.Cleanup(server.Close)
+
+			got, err := NewHTTPClient(server.URL, time.Second).GetOrderAccrual(context.Background(), "12345678903")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("GetOrderAccrual: got %+v, want an error", got)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("GetOrderAccrual: %v", err)
+			}
+
+			if got != tt.want {
+				t.Fatalf("GetOrderAccrual: got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPClientUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	if _, err := NewHTTPClient(server.URL, time.Second).GetOrderAccrual(context.Background(), "12345678903"); err == nil {
+		t.Fatal("GetOrderAccrual of an unreachable accrual system: got no error")
+	}
+}
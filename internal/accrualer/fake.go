@@ -0,0 +1,63 @@
+package accrualer
+
+import (
+	"context"
+	"sync"
+)
+
+// FakeClient answers from results set up beforehand, orders without one are
+// reported as not found.
+type FakeClient struct {
+	mu      sync.Mutex
+	results map[string]AccrualResult
+	errs    map[string]error
+	calls   map[string]int
+}
+
+func NewFakeClient() *FakeClient {
+	return &FakeClient{
+		results: make(map[string]AccrualResult),
+		errs:    make(map[string]error),
+		calls:   make(map[string]int),
+	}
+}
+
+func (c *FakeClient) SetResult(result AccrualResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.results[result.Number] = result
+	delete(c.errs, result.Number)
+}
+
+func (c *FakeClient) SetError(number string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.errs[number] = err
+	delete(c.results, number)
+}
+
+func (c *FakeClient) Calls(number string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.calls[number]
+}
+
+func (c *FakeClient) GetOrderAccrual(ctx context.Context, number string) (AccrualResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls[number]++
+
+	if err, ok := c.errs[number]; ok {
+		return AccrualResult{}, err
+	}
+
+	if result, ok := c.results[number]; ok {
+		return result, nil
+	}
+
+	return AccrualResult{Kind: ResultNotFound, Number: number}, nil
+}
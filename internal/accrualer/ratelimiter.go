@@ -0,0 +1,98 @@
+package accrualer
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// After a pause requests resume at this share of the learned limit and
+	// grow by rampFactor with every successful request.
+	rampStart  = 0.25
+	rampFactor = 1.1
+)
+
+// rateLimiter spaces out requests of all workers of the process. Until the
+// accrual system answers 429 for the first time requests are not limited.
+type rateLimiter struct {
+	mu sync.Mutex
+
+	pausedUntil time.Time
+	next        time.Time
+
+	// Requests per second: limit is learned from the 429 body, rate is the
+	// currently allowed one, 0 means unlimited.
+	limit float64
+	rate  float64
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{}
+}
+
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+
+	at := time.Now()
+	if l.pausedUntil.After(at) {
+		at = l.pausedUntil
+	}
+
+	if l.rate > 0 {
+		if l.next.After(at) {
+			at = l.next
+		}
+
+		l.next = at.Add(time.Duration(float64(time.Second) / l.rate))
+	}
+
+	l.mu.Unlock()
+
+	delay := time.Until(at)
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *rateLimiter) Throttle(retryAfter time.Duration, perMinute int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until := time.Now().Add(retryAfter); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+
+	if perMinute > 0 {
+		l.limit = float64(perMinute) / 60
+	}
+
+	if l.limit > 0 {
+		l.rate = l.limit * rampStart
+		l.next = l.pausedUntil
+	}
+}
+
+func (l *rateLimiter) Success() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate == 0 || l.rate >= l.limit {
+		return
+	}
+
+	l.rate *= rampFactor
+	if l.rate > l.limit {
+		l.rate = l.limit
+	}
+}
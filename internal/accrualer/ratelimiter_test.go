@@ -0,0 +1,175 @@
+package accrualer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VladKvetkin/gophermart/internal/entities"
+	"github.com/VladKvetkin/gophermart/internal/models"
+	"github.com/VladKvetkin/gophermart/internal/money"
+)
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	limiter := newRateLimiter()
+
+	started := time.Now()
+	for i := 0; i < 100; i++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+	}
+
+	if elapsed := time.Since(started); elapsed > 50*time.Millisecond {
+		t.Fatalf("requests before a 429: took %v, want no waiting", elapsed)
+	}
+}
+
+// TestRateLimiterPause checks that a pause holds back every waiting worker,
+// not only the one that got the 429.
+func TestRateLimiterPause(t *testing.T) {
+	const (
+		pause   = 200 * time.Millisecond
+		workers = 5
+	)
+
+	limiter := newRateLimiter()
+	limiter.Throttle(pause, 0)
+
+	started := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if err := limiter.Wait(context.Background()); err != nil {
+				t.Errorf("Wait: %v", err)
+				return
+			}
+
+			if elapsed := time.Since(started); elapsed < pause {
+				t.Errorf("Wait during a pause: returned after %v, want at least %v", elapsed, pause)
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestRateLimiterRamp(t *testing.T) {
+	limiter := newRateLimiter()
+
+	// 6000 requests per minute are 100 per second, requests resume at a
+	// quarter of it: 40ms apart.
+	limiter.Throttle(0, 6000)
+
+	started := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+	}
+
+	if elapsed := time.Since(started); elapsed < 80*time.Millisecond {
+		t.Fatalf("three requests after a throttle: took %v, want at least %v", elapsed, 80*time.Millisecond)
+	}
+
+	for i := 0; i < 100; i++ {
+		limiter.Success()
+	}
+
+	if limiter.rate != limiter.limit {
+		t.Fatalf("rate after successful requests: got %v, want the limit %v", limiter.rate, limiter.limit)
+	}
+}
+
+func TestRateLimiterWaitCancelled(t *testing.T) {
+	limiter := newRateLimiter()
+	limiter.Throttle(time.Hour, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := limiter.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Wait with a done context: got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+// TestAccrualerPausesOnRateLimit answers the first request with 429 and
+// checks that no worker, the sweep or the queue one, sends another request
+// before Retry-After has passed.
+func TestAccrualerPausesOnRateLimit(t *testing.T) {
+	const retryAfter = time.Second
+
+	var (
+		mu          sync.Mutex
+		limitedAt   time.Time
+		requestedAt []time.Time
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if limitedAt.IsZero() {
+			limitedAt = time.Now()
+
+			res.Header().Set("Retry-After", fmt.Sprint(int(retryAfter.Seconds())))
+			res.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(res, "No more than 6000 requests per minute allowed")
+			return
+		}
+
+		requestedAt = append(requestedAt, time.Now())
+
+		json.NewEncoder(res).Encode(models.AccrualAPIGetOrderResponse{
+			Number:  path.Base(req.URL.Path),
+			Status:  entities.OrderStatusProcessed,
+			Accrual: money.FromCents(1),
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	config := testConfig()
+	config.AccrualPollInterval = time.Hour
+
+	s, userID := newStorage(t)
+	createOrder(t, s, userID, "12345678903")
+	createOrder(t, s, userID, "4561261212345467")
+
+	accrualer := NewAccrualer(config, s, NewHTTPClient(server.URL, time.Second))
+	start(t, accrualer)
+
+	waitFor(t, "the 429", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return !limitedAt.IsZero()
+	})
+
+	createOrder(t, s, userID, "79927398713")
+	accrualer.Enqueue("79927398713")
+
+	for _, number := range []string{"12345678903", "4561261212345467", "79927398713"} {
+		waitOrderStatus(t, s, number, entities.OrderStatusProcessed)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, at := range requestedAt {
+		if paused := at.Sub(limitedAt); paused < retryAfter {
+			t.Fatalf("request after a 429: sent %v after it, want at least %v", paused, retryAfter)
+		}
+	}
+}
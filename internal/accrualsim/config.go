@@ -0,0 +1,65 @@
+package accrualsim
+
+import (
+	"errors"
+	"flag"
+	"net/url"
+	"time"
+
+	"github.com/caarlos0/env/v8"
+)
+
+type Config struct {
+	Address string `env:"RUN_ADDRESS"`
+
+	// Requests per minute allowed for GET /api/orders/{number}, 0 disables
+	// the limit.
+	RateLimit int `env:"ACCRUAL_RATE_LIMIT"`
+
+	// An order is REGISTERED for the first half of the delay, PROCESSING for
+	// the second and gets its final status afterwards.
+	ProcessingDelay time.Duration `env:"ACCRUAL_PROCESSING_DELAY"`
+}
+
+func NewConfig() (Config, error) {
+	config := Config{
+		Address:         "localhost:8081",
+		ProcessingDelay: 2 * time.Second,
+	}
+
+	config.parseFlags()
+
+	if err := env.Parse(&config); err != nil {
+		return Config{}, err
+	}
+
+	if err := config.validateConfig(); err != nil {
+		return Config{}, err
+	}
+
+	return config, nil
+}
+
+func (c *Config) parseFlags() {
+	flag.StringVar(&c.Address, "a", c.Address, "Service address")
+	flag.IntVar(&c.RateLimit, "rate-limit", c.RateLimit, "Order status requests per minute, 0 means unlimited")
+	flag.DurationVar(&c.ProcessingDelay, "processing-delay", c.ProcessingDelay, "Time an order takes to get its final status")
+
+	flag.Parse()
+}
+
+func (c *Config) validateConfig() error {
+	if _, err := url.ParseRequestURI(c.Address); err != nil {
+		return err
+	}
+
+	if c.RateLimit < 0 {
+		return errors.New("rate limit must not be negative")
+	}
+
+	if c.ProcessingDelay < 0 {
+		return errors.New("processing delay must not be negative")
+	}
+
+	return nil
+}
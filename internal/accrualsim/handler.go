@@ -0,0 +1,139 @@
+package accrualsim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/VladKvetkin/gophermart/internal/models"
+	"github.com/VladKvetkin/gophermart/internal/services/validation"
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+type RegisterOrderRequest struct {
+	Number string `json:"order"`
+	Goods  []Good `json:"goods"`
+}
+
+type Handler struct {
+	simulator *Simulator
+	limiter   *rateLimiter
+}
+
+func NewHandler(config Config, simulator *Simulator) *Handler {
+	return &Handler{
+		simulator: simulator,
+		limiter:   newRateLimiter(config.RateLimit),
+	}
+}
+
+func (h *Handler) Routes() http.Handler {
+	mux := chi.NewMux()
+
+	mux.Route("/api", func(r chi.Router) {
+		r.Post("/orders", http.HandlerFunc(h.RegisterOrder))
+		r.Get("/orders/{number}", http.HandlerFunc(h.GetOrder))
+		r.Post("/goods", http.HandlerFunc(h.RegisterRule))
+	})
+
+	return mux
+}
+
+func (h *Handler) RegisterOrder(res http.ResponseWriter, req *http.Request) {
+	var request RegisterOrderRequest
+
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		zap.L().Info("cannot decode request JSON body: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := validation.LuhnValidate(request.Number); err != nil {
+		zap.L().Info("luhn validation failed: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := h.simulator.RegisterOrder(request.Number, request.Goods); err != nil {
+		if errors.Is(err, ErrOrderRegistered) {
+			res.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res.WriteHeader(http.StatusAccepted)
+}
+
+func (h *Handler) RegisterRule(res http.ResponseWriter, req *http.Request) {
+	var rule RewardRule
+
+	if err := json.NewDecoder(req.Body).Decode(&rule); err != nil {
+		zap.L().Info("cannot decode request JSON body: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if rule.Match == "" || !rule.Reward.IsPositive() || (rule.RewardType != RewardTypePercent && rule.RewardType != RewardTypePoints) {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := h.simulator.RegisterRule(rule); err != nil {
+		if errors.Is(err, ErrRuleRegistered) {
+			res.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) GetOrder(res http.ResponseWriter, req *http.Request) {
+	if ok, retryAfter := h.limiter.Allow(); !ok {
+		res.Header().Set("Content-Type", "text/plain")
+		res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		res.WriteHeader(http.StatusTooManyRequests)
+
+		fmt.Fprintf(res, "No more than %d requests per minute allowed", h.limiter.perMinute)
+		return
+	}
+
+	number := chi.URLParam(req, "number")
+
+	status, err := h.simulator.OrderStatus(number)
+	if err != nil {
+		if errors.Is(err, ErrOrderNotFound) {
+			res.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+
+	response := models.AccrualAPIGetOrderResponse{
+		Number:  number,
+		Status:  status.Status,
+		Accrual: status.Accrual,
+	}
+
+	if err := json.NewEncoder(res).Encode(response); err != nil {
+		zap.L().Info("cannot encode response JSON body: %w", zap.Error(err))
+	}
+}
@@ -0,0 +1,45 @@
+package accrualsim
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter allows perMinute requests in every calendar minute.
+type rateLimiter struct {
+	mu sync.Mutex
+
+	perMinute int
+	window    time.Time
+	requests  int
+}
+
+func newRateLimiter(perMinute int) *rateLimiter {
+	return &rateLimiter{
+		perMinute: perMinute,
+	}
+}
+
+// Allow reports whether a request may be served and, if not, how long the
+// client has to wait.
+func (l *rateLimiter) Allow() (bool, time.Duration) {
+	if l.perMinute == 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if window := now.Truncate(time.Minute); !window.Equal(l.window) {
+		l.window = window
+		l.requests = 0
+	}
+
+	if l.requests < l.perMinute {
+		l.requests++
+		return true, 0
+	}
+
+	return false, l.window.Add(time.Minute).Sub(now)
+}
@@ -0,0 +1,160 @@
+package accrualsim
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VladKvetkin/gophermart/internal/entities"
+	"github.com/VladKvetkin/gophermart/internal/money"
+)
+
+const (
+	RewardTypePercent = "%"
+	RewardTypePoints  = "pt"
+
+	statusRegistered = "REGISTERED"
+)
+
+var (
+	ErrOrderRegistered = errors.New("order is already registered")
+	ErrRuleRegistered  = errors.New("reward rule is already registered")
+	ErrOrderNotFound   = errors.New("order is not registered")
+)
+
+type Good struct {
+	Description string       `json:"description"`
+	Price       money.Amount `json:"price"`
+}
+
+type RewardRule struct {
+	Match      string       `json:"match"`
+	Reward     money.Amount `json:"reward"`
+	RewardType string       `json:"reward_type"`
+}
+
+type OrderStatus struct {
+	Status  string
+	Accrual money.Amount
+}
+
+type order struct {
+	goods        []Good
+	registeredAt time.Time
+
+	// Set once the order is final, rules registered later do not change it.
+	result *OrderStatus
+}
+
+// Simulator keeps orders and reward rules in memory. Accrual of an order is
+// calculated once, by the rules known when it is first asked for after its
+// processing delay has passed.
+type Simulator struct {
+	mu sync.RWMutex
+
+	processingDelay time.Duration
+	orders          map[string]order
+	rules           []RewardRule
+}
+
+func NewSimulator(processingDelay time.Duration) *Simulator {
+	return &Simulator{
+		processingDelay: processingDelay,
+		orders:          make(map[string]order),
+	}
+}
+
+func (s *Simulator) RegisterOrder(number string, goods []Good) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.orders[number]; ok {
+		return ErrOrderRegistered
+	}
+
+	s.orders[number] = order{
+		goods:        goods,
+		registeredAt: time.Now(),
+	}
+
+	return nil
+}
+
+func (s *Simulator) RegisterRule(rule RewardRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, registered := range s.rules {
+		if strings.EqualFold(registered.Match, rule.Match) {
+			return ErrRuleRegistered
+		}
+	}
+
+	s.rules = append(s.rules, rule)
+
+	return nil
+}
+
+func (s *Simulator) OrderStatus(number string) (OrderStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order, ok := s.orders[number]
+	if !ok {
+		return OrderStatus{}, ErrOrderNotFound
+	}
+
+	if order.result != nil {
+		return *order.result, nil
+	}
+
+	elapsed := time.Since(order.registeredAt)
+
+	switch {
+	case elapsed < s.processingDelay/2:
+		return OrderStatus{Status: statusRegistered}, nil
+	case elapsed < s.processingDelay:
+		return OrderStatus{Status: entities.OrderStatusProcessing}, nil
+	}
+
+	result := OrderStatus{Status: entities.OrderStatusInvalid}
+
+	if accrual, matched := s.calculate(order.goods); matched {
+		result = OrderStatus{Status: entities.OrderStatusProcessed, Accrual: accrual}
+	}
+
+	order.result = &result
+	s.orders[number] = order
+
+	return result, nil
+}
+
+// calculate rewards every good by the first rule matching its description
+// and reports whether any good matched at all.
+func (s *Simulator) calculate(goods []Good) (money.Amount, bool) {
+	var (
+		accrual money.Amount
+		matched bool
+	)
+
+	for _, good := range goods {
+		for _, rule := range s.rules {
+			if !strings.Contains(strings.ToLower(good.Description), strings.ToLower(rule.Match)) {
+				continue
+			}
+
+			matched = true
+
+			if rule.RewardType == RewardTypePercent {
+				accrual += good.Price.Percent(rule.Reward)
+			} else {
+				accrual += rule.Reward
+			}
+
+			break
+		}
+	}
+
+	return accrual, matched
+}
@@ -1,20 +1,92 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"net/url"
+	"time"
 
 	"github.com/caarlos0/env/v8"
 )
 
+const (
+	StoragePostgres = "postgres"
+	StorageMemory   = "memory"
+
+	NotifierLog  = "log"
+	NotifierSMTP = "smtp"
+)
+
 type Config struct {
 	Address              string `env:"RUN_ADDRESS"`
 	DatabaseURI          string `env:"DATABASE_URI"`
 	AccrualSystemAddress string `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	Storage              string `env:"STORAGE"`
+
+	LeaderElectionInterval time.Duration `env:"LEADER_ELECTION_INTERVAL"`
+
+	JWTKeys           string        `env:"JWT_KEYS"`
+	JWTKeysFile       string        `env:"JWT_KEYS_FILE"`
+	JWTExpiry         time.Duration `env:"JWT_EXPIRY"`
+	JWTAllowRandomKey bool          `env:"JWT_ALLOW_RANDOM_KEY"`
+
+	RefreshTokenExpiry time.Duration `env:"REFRESH_TOKEN_EXPIRY"`
+	JWTIssuer          string        `env:"JWT_ISSUER"`
+	JWTAudience        string        `env:"JWT_AUDIENCE"`
+
+	AccrualPollInterval   time.Duration `env:"ACCRUAL_POLL_INTERVAL"`
+	AccrualCallbackSecret string        `env:"ACCRUAL_CALLBACK_SECRET"`
+	AccrualRequestTimeout time.Duration `env:"ACCRUAL_REQUEST_TIMEOUT"`
+	AccrualMaxAttempts    int           `env:"ACCRUAL_MAX_ATTEMPTS"`
+	AccrualRetryBase      time.Duration `env:"ACCRUAL_RETRY_BASE"`
+	AccrualRetryMax       time.Duration `env:"ACCRUAL_RETRY_MAX"`
+
+	AccrualBreakerFailures    int           `env:"ACCRUAL_BREAKER_FAILURES"`
+	AccrualBreakerOpenTimeout time.Duration `env:"ACCRUAL_BREAKER_OPEN_TIMEOUT"`
+
+	LoginMaxFailures    int           `env:"LOGIN_MAX_FAILURES"`
+	LoginIPMaxFailures  int           `env:"LOGIN_IP_MAX_FAILURES"`
+	LoginClientIPHeader string        `env:"LOGIN_CLIENT_IP_HEADER"`
+	LoginFailureDelay   time.Duration `env:"LOGIN_FAILURE_DELAY"`
+	LoginLockoutBase    time.Duration `env:"LOGIN_LOCKOUT_BASE"`
+	LoginLockoutMax     time.Duration `env:"LOGIN_LOCKOUT_MAX"`
+
+	PasswordResetExpiry      time.Duration `env:"PASSWORD_RESET_EXPIRY"`
+	PasswordResetMaxRequests int           `env:"PASSWORD_RESET_MAX_REQUESTS"`
+	Notifier                 string        `env:"NOTIFIER"`
+	SMTPAddress              string        `env:"SMTP_ADDRESS"`
+	SMTPFrom                 string        `env:"SMTP_FROM"`
 }
 
 func NewConfig() (Config, error) {
-	config := Config{}
+	config := Config{
+		Storage:                StoragePostgres,
+		LeaderElectionInterval: 5 * time.Second,
+		JWTExpiry:              15 * time.Minute,
+		RefreshTokenExpiry:     30 * 24 * time.Hour,
+		JWTIssuer:              "gophermart",
+		JWTAudience:            "gophermart",
+		AccrualPollInterval:    3 * time.Second,
+		AccrualRequestTimeout:  5 * time.Second,
+		AccrualMaxAttempts:     20,
+		AccrualRetryBase:       3 * time.Second,
+		AccrualRetryMax:        time.Hour,
+
+		AccrualBreakerFailures:    5,
+		AccrualBreakerOpenTimeout: 30 * time.Second,
+
+		LoginMaxFailures:   5,
+		LoginIPMaxFailures: 20,
+		LoginFailureDelay:  time.Second,
+		LoginLockoutBase:   30 * time.Second,
+		LoginLockoutMax:    time.Hour,
+
+		PasswordResetExpiry:      time.Hour,
+		PasswordResetMaxRequests: 3,
+		SMTPAddress:              "localhost:25",
+		SMTPFrom:                 "gophermart@localhost",
+	}
 
 	config.parseFlags()
 
@@ -29,10 +101,46 @@ func NewConfig() (Config, error) {
 	return config, nil
 }
 
+func (c Config) UseMemoryStorage() bool {
+	return c.Storage == StorageMemory
+}
+
+func (c Config) UseSMTPNotifier() bool {
+	return c.Notifier == NotifierSMTP
+}
+
 func (c *Config) parseFlags() {
 	flag.StringVar(&c.Address, "a", c.Address, "Service address")
 	flag.StringVar(&c.DatabaseURI, "d", c.DatabaseURI, "Database URI")
 	flag.StringVar(&c.AccrualSystemAddress, "r", c.AccrualSystemAddress, "Accrual system address")
+	flag.StringVar(&c.Storage, "s", c.Storage, "Storage backend: postgres or memory")
+	flag.DurationVar(&c.LeaderElectionInterval, "leader-election-interval", c.LeaderElectionInterval, "Interval of leader lock attempts and leader connection checks")
+	flag.StringVar(&c.JWTKeys, "jwt-keys", c.JWTKeys, "Comma separated kid:secret JWT keys, the first one signs new tokens")
+	flag.StringVar(&c.JWTKeysFile, "jwt-keys-file", c.JWTKeysFile, "File with one kid:secret JWT key per line, used after -jwt-keys")
+	flag.DurationVar(&c.JWTExpiry, "jwt-expiry", c.JWTExpiry, "Lifetime of issued access tokens")
+	flag.BoolVar(&c.JWTAllowRandomKey, "jwt-allow-random-key", c.JWTAllowRandomKey, "Sign tokens with a random key when no JWT keys are configured, for development with memory storage only")
+	flag.DurationVar(&c.RefreshTokenExpiry, "refresh-token-expiry", c.RefreshTokenExpiry, "Time a session lives without being refreshed")
+	flag.StringVar(&c.JWTIssuer, "jwt-issuer", c.JWTIssuer, "Issuer of issued and accepted tokens")
+	flag.StringVar(&c.JWTAudience, "jwt-audience", c.JWTAudience, "Audience of issued and accepted tokens")
+	flag.DurationVar(&c.AccrualPollInterval, "accrual-poll-interval", c.AccrualPollInterval, "Interval between accrual system polls, can be raised when callbacks are enabled")
+	flag.StringVar(&c.AccrualCallbackSecret, "accrual-callback-secret", c.AccrualCallbackSecret, "Shared secret of accrual callbacks, callbacks are disabled when empty")
+	flag.DurationVar(&c.AccrualRequestTimeout, "accrual-request-timeout", c.AccrualRequestTimeout, "Timeout of a single accrual system request")
+	flag.IntVar(&c.AccrualMaxAttempts, "accrual-max-attempts", c.AccrualMaxAttempts, "Failed accrual checks before an order is marked invalid")
+	flag.DurationVar(&c.AccrualRetryBase, "accrual-retry-base", c.AccrualRetryBase, "Delay before the first accrual check retry")
+	flag.DurationVar(&c.AccrualRetryMax, "accrual-retry-max", c.AccrualRetryMax, "Maximum delay between accrual check retries")
+	flag.IntVar(&c.AccrualBreakerFailures, "accrual-breaker-failures", c.AccrualBreakerFailures, "Consecutive accrual system failures that open the circuit breaker")
+	flag.DurationVar(&c.AccrualBreakerOpenTimeout, "accrual-breaker-open-timeout", c.AccrualBreakerOpenTimeout, "Time the circuit breaker stays open before a probe request")
+	flag.IntVar(&c.LoginMaxFailures, "login-max-failures", c.LoginMaxFailures, "Failed logins for one login before it is locked out")
+	flag.IntVar(&c.LoginIPMaxFailures, "login-ip-max-failures", c.LoginIPMaxFailures, "Failed logins, and separately password reset requests, from one client IP before it is locked out")
+	flag.StringVar(&c.LoginClientIPHeader, "login-client-ip-header", c.LoginClientIPHeader, "Header the trusted reverse proxy puts the client IP in, failed logins are not limited per IP without it")
+	flag.DurationVar(&c.LoginFailureDelay, "login-failure-delay", c.LoginFailureDelay, "Delay after the first failed login, doubled on every further failure below the lockout limit")
+	flag.DurationVar(&c.LoginLockoutBase, "login-lockout-base", c.LoginLockoutBase, "Lockout after the first exceeded failure, doubled on every further failure")
+	flag.DurationVar(&c.LoginLockoutMax, "login-lockout-max", c.LoginLockoutMax, "Maximum lockout, also the time after which failures are forgotten")
+	flag.DurationVar(&c.PasswordResetExpiry, "password-reset-expiry", c.PasswordResetExpiry, "Lifetime of password reset tokens")
+	flag.IntVar(&c.PasswordResetMaxRequests, "password-reset-max-requests", c.PasswordResetMaxRequests, "Password reset requests for one login before further ones are locked out")
+	flag.StringVar(&c.Notifier, "notifier", c.Notifier, "Delivery of password reset tokens: smtp, or log with memory storage, which only logs that a reset was requested")
+	flag.StringVar(&c.SMTPAddress, "smtp-address", c.SMTPAddress, "Address of the SMTP relay used by the smtp notifier")
+	flag.StringVar(&c.SMTPFrom, "smtp-from", c.SMTPFrom, "Sender address of mails sent by the smtp notifier")
 
 	flag.Parse()
 }
@@ -45,5 +153,79 @@ func (c *Config) validateConfig() error {
 		}
 	}
 
+	switch c.Storage {
+	case StoragePostgres:
+		if c.DatabaseURI == "" {
+			return errors.New("database URI is required for postgres storage")
+		}
+	case StorageMemory:
+	default:
+		return fmt.Errorf("unknown storage %q", c.Storage)
+	}
+
+	if c.LeaderElectionInterval <= 0 {
+		return errors.New("leader election interval must be positive")
+	}
+
+	if c.JWTExpiry <= 0 || c.JWTIssuer == "" || c.JWTAudience == "" {
+		return errors.New("JWT expiry must be positive, issuer and audience must be set")
+	}
+
+	// Replicas sharing a database must share their keys too.
+	if c.JWTAllowRandomKey && !c.UseMemoryStorage() {
+		return errors.New("random JWT key is allowed with memory storage only")
+	}
+
+	if c.RefreshTokenExpiry < c.JWTExpiry {
+		return errors.New("refresh token expiry must not be shorter than JWT expiry")
+	}
+
+	if c.AccrualPollInterval <= 0 || c.AccrualRequestTimeout <= 0 {
+		return errors.New("accrual poll interval and request timeout must be positive")
+	}
+
+	if c.AccrualMaxAttempts <= 0 {
+		return errors.New("accrual max attempts must be positive")
+	}
+
+	if c.AccrualRetryBase <= 0 || c.AccrualRetryMax < c.AccrualRetryBase {
+		return errors.New("accrual retry delays must be positive and base must not exceed max")
+	}
+
+	if c.AccrualBreakerFailures <= 0 || c.AccrualBreakerOpenTimeout <= 0 {
+		return errors.New("accrual circuit breaker thresholds must be positive")
+	}
+
+	if c.LoginMaxFailures <= 0 || c.LoginIPMaxFailures <= 0 {
+		return errors.New("login failure limits must be positive")
+	}
+
+	if c.LoginLockoutBase <= 0 || c.LoginLockoutMax < c.LoginLockoutBase {
+		return errors.New("login lockouts must be positive and base must not exceed max")
+	}
+
+	if c.LoginFailureDelay < 0 || c.LoginFailureDelay > c.LoginLockoutBase {
+		return errors.New("login failure delay must not be negative or exceed the lockout base")
+	}
+
+	if c.PasswordResetExpiry <= 0 || c.PasswordResetMaxRequests <= 0 {
+		return errors.New("password reset expiry and max requests must be positive")
+	}
+
+	switch c.Notifier {
+	case "":
+		return errors.New("notifier is required: smtp, or log with memory storage")
+	case NotifierLog:
+		if !c.UseMemoryStorage() {
+			return errors.New("log notifier is allowed with memory storage only")
+		}
+	case NotifierSMTP:
+		if c.SMTPAddress == "" || c.SMTPFrom == "" {
+			return errors.New("SMTP address and sender are required for smtp notifier")
+		}
+	default:
+		return fmt.Errorf("unknown notifier %q", c.Notifier)
+	}
+
 	return nil
 }
@@ -0,0 +1,23 @@
+package entities
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	LedgerEntryKindAccrual    = "ACCRUAL"
+	LedgerEntryKindWithdrawal = "WITHDRAWAL"
+	LedgerEntryKindAdjustment = "ADJUSTMENT"
+)
+
+// LedgerEntry is a single-sided posting to the account of a user: accruals
+// are positive, withdrawals negative and adjustments either.
+type LedgerEntry struct {
+	ID          string         `db:"id"`
+	UserID      string         `db:"user_id"`
+	Kind        string         `db:"kind"`
+	Amount      int            `db:"amount"`
+	OrderNumber sql.NullString `db:"order_number"`
+	CreatedAt   time.Time      `db:"created_at"`
+}
@@ -1,6 +1,7 @@
 package entities
 
 import (
+	"database/sql"
 	"time"
 )
 
@@ -12,13 +13,17 @@ const (
 )
 
 type Order struct {
-	ID        string    `db:"id"`
-	Number    string    `db:"number"`
-	CreatedAt time.Time `db:"created_at"`
-	UpdatedAt time.Time `db:"updated_at"`
-	Status    string    `db:"status"`
-	UserID    string    `db:"user_id"`
-	Accrual   int       `db:"accrual"`
+	ID             string         `db:"id"`
+	Number         string         `db:"number"`
+	CreatedAt      time.Time      `db:"created_at"`
+	UpdatedAt      time.Time      `db:"updated_at"`
+	Status         string         `db:"status"`
+	UserID         string         `db:"user_id"`
+	Accrual        int            `db:"accrual"`
+	LeaseExpiresAt sql.NullTime   `db:"lease_expires_at"`
+	Attempts       int            `db:"attempts"`
+	LastError      sql.NullString `db:"last_error"`
+	NextAttemptAt  time.Time      `db:"next_attempt_at"`
 }
 
 type Withdrawal struct {
@@ -0,0 +1,78 @@
+package entities
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrForbiddenTransition = errors.New("forbidden order status transition")
+
+type TransitionError struct {
+	From string
+	To   string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("%v: %s -> %s", ErrForbiddenTransition, e.From, e.To)
+}
+
+func (e *TransitionError) Unwrap() error {
+	return ErrForbiddenTransition
+}
+
+// PROCESSED and INVALID are final, nothing leaves them.
+var orderStatusTransitions = map[string][]string{
+	OrderStatusNew:        {OrderStatusNew, OrderStatusProcessing, OrderStatusProcessed, OrderStatusInvalid},
+	OrderStatusProcessing: {OrderStatusProcessing, OrderStatusProcessed, OrderStatusInvalid},
+}
+
+var accrualStatuses = map[string]string{
+	"REGISTERED": OrderStatusNew,
+	"PROCESSING": OrderStatusProcessing,
+	"PROCESSED":  OrderStatusProcessed,
+	"INVALID":    OrderStatusInvalid,
+}
+
+func ParseAccrualStatus(status string) (string, error) {
+	orderStatus, ok := accrualStatuses[status]
+	if !ok {
+		return "", fmt.Errorf("unknown accrual status %q", status)
+	}
+
+	return orderStatus, nil
+}
+
+func IsFinalOrderStatus(status string) bool {
+	return status == OrderStatusProcessed || status == OrderStatusInvalid
+}
+
+func CanTransitOrder(from string, to string) bool {
+	for _, status := range orderStatusTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+func ValidateOrderTransition(from string, to string) error {
+	if !CanTransitOrder(from, to) {
+		return &TransitionError{From: from, To: to}
+	}
+
+	return nil
+}
+
+// OrderStatusesTransitableTo lists the statuses an order may be in to move
+// to the given one, for guarding conditional updates in storage.
+func OrderStatusesTransitableTo(to string) []string {
+	var from []string
+	for status := range orderStatusTransitions {
+		if CanTransitOrder(status, to) {
+			from = append(from, status)
+		}
+	}
+
+	return from
+}
@@ -0,0 +1,15 @@
+package entities
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Session struct {
+	ID               string       `db:"id"`
+	UserID           string       `db:"user_id"`
+	RefreshTokenHash string       `db:"refresh_token_hash"`
+	CreatedAt        time.Time    `db:"created_at"`
+	ExpiresAt        time.Time    `db:"expires_at"`
+	RevokedAt        sql.NullTime `db:"revoked_at"`
+}
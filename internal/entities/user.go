@@ -0,0 +1,17 @@
+package entities
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+type User struct {
+	ID           string `db:"id"`
+	Login        string `db:"login"`
+	PasswordHash string `db:"password"`
+	Role         string `db:"role"`
+}
+
+func IsValidRole(role string) bool {
+	return role == RoleUser || role == RoleAdmin
+}
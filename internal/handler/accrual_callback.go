@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/VladKvetkin/gophermart/internal/entities"
+	"github.com/VladKvetkin/gophermart/internal/models"
+	"github.com/VladKvetkin/gophermart/internal/storage"
+	"go.uber.org/zap"
+)
+
+// AccrualSignatureHeader carries the hex encoded HMAC-SHA256 of the request
+// body keyed with the shared callback secret, optionally prefixed by "sha256=".
+const AccrualSignatureHeader = "X-Accrual-Signature"
+
+func (h *Handler) AccrualCallback(res http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		zap.L().Info("cannot read accrual callback body: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if !h.validAccrualSignature(body, req.Header.Get(AccrualSignatureHeader)) {
+		zap.L().Info("invalid accrual callback signature")
+
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var payload models.AccrualAPIGetOrderResponse
+	if err := json.Unmarshal(body, &payload); err != nil || payload.Number == "" {
+		zap.L().Info("cannot decode accrual callback to json: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	status, err := entities.ParseAccrualStatus(payload.Status)
+	if err != nil {
+		zap.L().Info("error parse accrual callback status: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	order, err := h.storage.GetOrderByNumber(req.Context(), payload.Number)
+	if err != nil {
+		if errors.Is(err, storage.ErrNoRows) {
+			zap.L().Info("accrual callback for unknown order: %v", zap.String("Number", payload.Number))
+
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		zap.L().Info("error get order by number: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.storage.UpdateOrder(req.Context(), order, payload.Accrual.Cents(), status); err != nil {
+		if errors.Is(err, entities.ErrForbiddenTransition) {
+			zap.L().Info("error apply accrual callback: %w", zap.Error(err))
+
+			res.WriteHeader(http.StatusConflict)
+			return
+		}
+
+		zap.L().Info("error update order: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) validAccrualSignature(body []byte, signature string) bool {
+	got, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil || len(got) == 0 {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(h.config.AccrualCallbackSecret))
+	mac.Write(body)
+
+	return hmac.Equal(got, mac.Sum(nil))
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/VladKvetkin/gophermart/internal/entities"
+	"github.com/VladKvetkin/gophermart/internal/models"
+	"github.com/VladKvetkin/gophermart/internal/storage"
+	"github.com/go-chi/chi"
+	"go.uber.org/zap"
+)
+
+func (h *Handler) SetUserRole(res http.ResponseWriter, req *http.Request) {
+	var requestModel models.SetRoleRequest
+	if err := json.NewDecoder(req.Body).Decode(&requestModel); err != nil || !entities.IsValidRole(requestModel.Role) {
+		zap.L().Info("error validate set role request", zap.Error(err))
+
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	login, err := loginFromURL(req)
+	if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.storage.GetUserByLogin(req.Context(), login)
+	if err != nil {
+		if errors.Is(err, storage.ErrNoRows) {
+			res.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		zap.L().Info("error get user: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.storage.SetUserRole(req.Context(), user.ID, requestModel.Role); err != nil {
+		zap.L().Info("error set user role: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	zap.L().Info(
+		"user role changed",
+		zap.String("login", user.Login),
+		zap.String("role", requestModel.Role),
+		zap.String("by", h.getUserIDFromReqContext(req)),
+	)
+
+	res.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) UnlockLogin(res http.ResponseWriter, req *http.Request) {
+	login, err := loginFromURL(req)
+	if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := h.loginGuard.Unlock(req.Context(), login); err != nil {
+		zap.L().Info("error unlock login: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res.WriteHeader(http.StatusOK)
+}
+
+// loginFromURL decodes the login path parameter, chi matches escaped paths
+// on their raw form.
+func loginFromURL(req *http.Request) (string, error) {
+	return url.PathUnescape(chi.URLParam(req, "login"))
+}
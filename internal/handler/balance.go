@@ -5,7 +5,7 @@ import (
 	"net/http"
 
 	"github.com/VladKvetkin/gophermart/internal/models"
-	"github.com/VladKvetkin/gophermart/internal/services/converter"
+	"github.com/VladKvetkin/gophermart/internal/money"
 	"go.uber.org/zap"
 )
 
@@ -33,8 +33,8 @@ func (h *Handler) GetBalance(res http.ResponseWriter, req *http.Request) {
 	}
 
 	response := models.GetBalanceResponse{
-		Accrual:   converter.FormatAccrual(currentAccrual),
-		Withdranw: converter.FormatAccrual(withdrawn),
+		Accrual:   money.FromCents(currentAccrual),
+		Withdranw: money.FromCents(withdrawn),
 	}
 
 	res.Header().Set("Content-Type", "application/json")
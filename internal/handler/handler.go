@@ -3,17 +3,33 @@ package handler
 import (
 	"net/http"
 
+	"github.com/VladKvetkin/gophermart/internal/config"
 	"github.com/VladKvetkin/gophermart/internal/middleware"
+	"github.com/VladKvetkin/gophermart/internal/services/jwttoken"
+	"github.com/VladKvetkin/gophermart/internal/services/loginguard"
+	"github.com/VladKvetkin/gophermart/internal/services/notifier"
 	"github.com/VladKvetkin/gophermart/internal/storage"
 )
 
 type Handler struct {
-	storage storage.Storage
+	config        config.Config
+	storage       storage.Storage
+	tokens        *jwttoken.Manager
+	accrualHealth AccrualHealth
+	accrualQueue  AccrualQueue
+	notifier      notifier.Notifier
+	loginGuard    *loginguard.Guard
 }
 
-func NewHandler(storage storage.Storage) *Handler {
+func NewHandler(config config.Config, storage storage.Storage, tokens *jwttoken.Manager, accrualHealth AccrualHealth, accrualQueue AccrualQueue, notifier notifier.Notifier) *Handler {
 	return &Handler{
-		storage: storage,
+		config:        config,
+		storage:       storage,
+		tokens:        tokens,
+		accrualHealth: accrualHealth,
+		accrualQueue:  accrualQueue,
+		notifier:      notifier,
+		loginGuard:    loginguard.NewGuard(config, storage),
 	}
 }
 
@@ -25,3 +41,12 @@ func (h *Handler) getUserIDFromReqContext(req *http.Request) string {
 
 	return userID
 }
+
+func (h *Handler) getSessionIDFromReqContext(req *http.Request) string {
+	sessionID, ok := req.Context().Value(middleware.SessionIDKey{}).(string)
+	if !ok {
+		return ""
+	}
+
+	return sessionID
+}
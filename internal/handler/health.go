@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/VladKvetkin/gophermart/internal/accrualer"
+	"github.com/VladKvetkin/gophermart/internal/models"
+	"go.uber.org/zap"
+)
+
+const (
+	healthStatusOK       = "ok"
+	healthStatusDegraded = "degraded"
+)
+
+type AccrualHealth interface {
+	BreakerState(context.Context) string
+}
+
+type AccrualQueue interface {
+	Enqueue(string)
+}
+
+func (h *Handler) Health(res http.ResponseWriter, req *http.Request) {
+	response := models.HealthResponse{
+		Status:  healthStatusOK,
+		Accrual: h.accrualHealth.BreakerState(req.Context()),
+	}
+
+	if response.Accrual != accrualer.BreakerClosed {
+		response.Status = healthStatusDegraded
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+
+	jsonEncoder := json.NewEncoder(res)
+	if err := jsonEncoder.Encode(response); err != nil {
+		zap.L().Info("cannot encode response JSON body: %w", zap.Error(err))
+	}
+}
@@ -1,16 +1,17 @@
 package handler
 
 import (
-	"crypto/sha256"
-	"encoding/base64"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
-	"github.com/VladKvetkin/gophermart/internal/middleware"
 	"github.com/VladKvetkin/gophermart/internal/models"
-	"github.com/VladKvetkin/gophermart/internal/services/jwttoken"
+	"github.com/VladKvetkin/gophermart/internal/services/password"
 	"github.com/VladKvetkin/gophermart/internal/storage"
 	"go.uber.org/zap"
 )
@@ -24,10 +25,27 @@ func (h *Handler) Login(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	userID, err := h.storage.GetUser(req.Context(), requestModel.Login, h.generatePasswordHash(requestModel.Password))
+	lockout, err := h.loginGuard.Attempt(req.Context(), requestModel.Login, h.loginGuard.ClientIP(req))
+	if err != nil {
+		zap.L().Info("error record login attempt: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if lockout > 0 {
+		zap.L().Info("error login locked out", zap.String("login", requestModel.Login), zap.Duration("lockout", lockout))
+
+		writeLockout(res, lockout)
+		return
+	}
+
+	user, err := h.storage.GetUserByLogin(req.Context(), requestModel.Login)
 	if err != nil {
 		if errors.Is(err, storage.ErrNoRows) {
-			zap.L().Info("error login and password hash not found: %w", zap.Error(err))
+			zap.L().Info("error login not found: %w", zap.Error(err))
+
+			password.VerifyDummy(requestModel.Password)
 
 			res.WriteHeader(http.StatusUnauthorized)
 			return
@@ -39,12 +57,49 @@ func (h *Handler) Login(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	h.generateTokenAndSetCookie(res, userID)
+	ok, rehash, err := password.Verify(requestModel.Password, user.PasswordHash)
+	if err != nil {
+		zap.L().Info("error verify password: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !ok {
+		zap.L().Info("error wrong password", zap.String("login", user.Login))
+
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Hashes of older formats are replaced while the password is at hand,
+	// a failure here must not stop the user from logging in.
+	if rehash {
+		h.rehashPassword(req.Context(), user.ID, requestModel.Password)
+	}
+
+	if err := h.loginGuard.Success(req.Context(), user.Login, h.loginGuard.ClientIP(req)); err != nil {
+		zap.L().Info("error reset login failures: %w", zap.Error(err))
+	}
+
+	h.startSession(res, req, user.ID, user.Role)
 }
 
-func (h *Handler) generatePasswordHash(password string) string {
-	passwordHash := sha256.Sum256([]byte(password))
-	return base64.StdEncoding.EncodeToString(passwordHash[:])
+func writeLockout(res http.ResponseWriter, lockout time.Duration) {
+	res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(lockout.Seconds()))))
+	res.WriteHeader(http.StatusTooManyRequests)
+}
+
+func (h *Handler) rehashPassword(ctx context.Context, userID string, plainPassword string) {
+	passwordHash, err := password.Hash(plainPassword)
+	if err != nil {
+		zap.L().Info("error hash password: %w", zap.Error(err))
+		return
+	}
+
+	if err := h.storage.UpdateUserPassword(ctx, userID, passwordHash); err != nil {
+		zap.L().Info("error update password hash: %w", zap.Error(err))
+	}
 }
 
 func (h *Handler) validateAuthorizationRequest(req *http.Request) (models.AuthorizationRequst, error) {
@@ -62,19 +117,3 @@ func (h *Handler) validateAuthorizationRequest(req *http.Request) (models.Author
 
 	return requestModel, nil
 }
-
-func (h *Handler) generateTokenAndSetCookie(res http.ResponseWriter, userID string) {
-	accessToken, err := jwttoken.Generate(userID)
-	if err != nil {
-		res.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	http.SetCookie(res, &http.Cookie{
-		Name:  middleware.TokenCookieName,
-		Value: accessToken,
-		Path:  "/",
-	})
-
-	res.WriteHeader(http.StatusOK)
-}
@@ -0,0 +1,130 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VladKvetkin/gophermart/internal/config"
+	"github.com/VladKvetkin/gophermart/internal/handler"
+	"github.com/VladKvetkin/gophermart/internal/models"
+	"github.com/VladKvetkin/gophermart/internal/services/notifier"
+	"github.com/VladKvetkin/gophermart/internal/services/password"
+	"github.com/VladKvetkin/gophermart/internal/storage"
+	"github.com/VladKvetkin/gophermart/internal/storage/storagetest"
+	"go.uber.org/zap"
+)
+
+func TestLoginLockout(t *testing.T) {
+	for name, newStorage := range map[string]storagetest.Factory{
+		"Memory":   storagetest.Memory,
+		"Postgres": storagetest.Postgres,
+	} {
+		newStorage := newStorage
+		t.Run(name, func(t *testing.T) {
+			t.Run("Concurrent", func(t *testing.T) {
+				testLoginLockoutConcurrent(t, newStorage(t))
+			})
+
+			t.Run("FailureDelay", func(t *testing.T) {
+				testLoginFailureDelay(t, newStorage(t))
+			})
+		})
+	}
+}
+
+// testLoginLockoutConcurrent sends many parallel guesses of a password: no
+// more of them than the failure limit may reach the password check.
+func testLoginLockoutConcurrent(t *testing.T, s storage.Storage) {
+	const (
+		maxFailures = 3
+		guesses     = 20
+	)
+
+	createLoginUser(t, s)
+
+	h := handler.NewHandler(loginConfig(maxFailures, 0), s, nil, nil, nil, notifier.NewLogNotifier(zap.NewNop()))
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		statuses = make(map[int]int)
+	)
+
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			code := login(t, h, "wrong")
+
+			mu.Lock()
+			statuses[code]++
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	if statuses[http.StatusUnauthorized] != maxFailures || statuses[http.StatusTooManyRequests] != guesses-maxFailures {
+		t.Fatalf("statuses of concurrent guesses: got %v, want %d %d and the rest %d", statuses, maxFailures, http.StatusUnauthorized, http.StatusTooManyRequests)
+	}
+}
+
+// testLoginFailureDelay checks that a failure below the limit already
+// delays the next attempt, even one with the right password.
+func testLoginFailureDelay(t *testing.T, s storage.Storage) {
+	createLoginUser(t, s)
+
+	h := handler.NewHandler(loginConfig(5, time.Minute), s, nil, nil, nil, notifier.NewLogNotifier(zap.NewNop()))
+
+	if code := login(t, h, "wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("login with wrong password: got %d, want %d", code, http.StatusUnauthorized)
+	}
+
+	if code := login(t, h, "password"); code != http.StatusTooManyRequests {
+		t.Fatalf("login right after a failure: got %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
+
+func loginConfig(maxFailures int, failureDelay time.Duration) config.Config {
+	return config.Config{
+		LoginMaxFailures:   maxFailures,
+		LoginIPMaxFailures: 100,
+		LoginFailureDelay:  failureDelay,
+		LoginLockoutBase:   time.Hour,
+		LoginLockoutMax:    time.Hour,
+	}
+}
+
+func createLoginUser(t *testing.T, s storage.Storage) {
+	t.Helper()
+
+	passwordHash, err := password.Hash("password")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+
+	if _, err := s.CreateUser(context.Background(), "user", passwordHash); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+}
+
+func login(t *testing.T, h *handler.Handler, plainPassword string) int {
+	body, err := json.Marshal(models.AuthorizationRequst{Login: "user", Password: plainPassword})
+	if err != nil {
+		t.Errorf("encode request: %v", err)
+		return 0
+	}
+
+	res := httptest.NewRecorder()
+	h.Login(res, httptest.NewRequest(http.MethodPost, "/api/user/login", bytes.NewReader(body)))
+
+	return res.Code
+}
@@ -7,7 +7,7 @@ import (
 	"time"
 
 	"github.com/VladKvetkin/gophermart/internal/models"
-	"github.com/VladKvetkin/gophermart/internal/services/converter"
+	"github.com/VladKvetkin/gophermart/internal/money"
 	"github.com/VladKvetkin/gophermart/internal/services/validation"
 	"go.uber.org/zap"
 )
@@ -45,6 +45,8 @@ func (h *Handler) SaveOrder(res http.ResponseWriter, req *http.Request) {
 	}
 
 	if isNewOrder {
+		h.accrualQueue.Enqueue(order.Number)
+
 		res.WriteHeader(http.StatusAccepted)
 		return
 	}
@@ -88,7 +90,7 @@ func (h *Handler) GetOrders(res http.ResponseWriter, req *http.Request) {
 		}
 
 		if order.Accrual != 0 {
-			responseOrder.Accrual = converter.FormatAccrual(order.Accrual)
+			responseOrder.Accrual = money.FromCents(order.Accrual)
 		}
 
 		responseOrders = append(responseOrders, responseOrder)
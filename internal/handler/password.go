@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/VladKvetkin/gophermart/internal/models"
+	"github.com/VladKvetkin/gophermart/internal/services/password"
+	"github.com/VladKvetkin/gophermart/internal/storage"
+	"go.uber.org/zap"
+)
+
+// passwordResetTimeout bounds the delivery of a reset token, which goes on
+// after the request is answered.
+const passwordResetTimeout = 30 * time.Second
+
+func (h *Handler) ChangePassword(res http.ResponseWriter, req *http.Request) {
+	userID := h.getUserIDFromReqContext(req)
+	if userID == "" {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	var requestModel models.ChangePasswordRequest
+	if err := json.NewDecoder(req.Body).Decode(&requestModel); err != nil || requestModel.CurrentPassword == "" || requestModel.NewPassword == "" {
+		zap.L().Info("error validate change password request", zap.Error(err))
+
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	user, err := h.storage.GetUserByID(req.Context(), userID)
+	if err != nil {
+		zap.L().Info("error get user: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Guessing the current password with a stolen session is limited the
+	// same way as guessing it at login.
+	lockout, err := h.loginGuard.Attempt(req.Context(), user.Login, h.loginGuard.ClientIP(req))
+	if err != nil {
+		zap.L().Info("error record login attempt: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if lockout > 0 {
+		zap.L().Info("error login locked out", zap.String("login", user.Login), zap.Duration("lockout", lockout))
+
+		writeLockout(res, lockout)
+		return
+	}
+
+	ok, _, err := password.Verify(requestModel.CurrentPassword, user.PasswordHash)
+	if err != nil {
+		zap.L().Info("error verify password: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if !ok {
+		zap.L().Info("error wrong current password", zap.String("login", user.Login))
+
+		res.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if err := h.loginGuard.Success(req.Context(), user.Login, h.loginGuard.ClientIP(req)); err != nil {
+		zap.L().Info("error reset login failures: %w", zap.Error(err))
+	}
+
+	passwordHash, err := password.Hash(requestModel.NewPassword)
+	if err != nil {
+		zap.L().Info("error hash password: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.storage.ChangeUserPassword(req.Context(), userID, passwordHash, h.getSessionIDFromReqContext(req)); err != nil {
+		zap.L().Info("error change password: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res.WriteHeader(http.StatusOK)
+}
+
+// RequestPasswordReset answers before the login is even looked up and
+// delivers the token in the background, so neither the answer nor its
+// timing tells registered logins apart from unknown ones.
+func (h *Handler) RequestPasswordReset(res http.ResponseWriter, req *http.Request) {
+	var requestModel models.PasswordResetRequest
+	if err := json.NewDecoder(req.Body).Decode(&requestModel); err != nil || requestModel.Login == "" {
+		zap.L().Info("error validate password reset request", zap.Error(err))
+
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	lockout, err := h.loginGuard.PasswordResetRequest(req.Context(), requestModel.Login, h.loginGuard.ClientIP(req))
+	if err != nil {
+		zap.L().Info("error record password reset request: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if lockout > 0 {
+		zap.L().Info("error password reset locked out", zap.String("login", requestModel.Login), zap.Duration("lockout", lockout))
+
+		writeLockout(res, lockout)
+		return
+	}
+
+	res.WriteHeader(http.StatusAccepted)
+
+	go h.sendPasswordReset(requestModel.Login)
+}
+
+// sendPasswordReset issues a reset token for login and delivers it, errors
+// are only logged as the request is answered already.
+func (h *Handler) sendPasswordReset(login string) {
+	ctx, cancel := context.WithTimeout(context.Background(), passwordResetTimeout)
+	defer cancel()
+
+	user, err := h.storage.GetUserByLogin(ctx, login)
+	if err != nil {
+		if errors.Is(err, storage.ErrNoRows) {
+			zap.L().Info("error password reset of unknown login", zap.String("login", login))
+			return
+		}
+
+		zap.L().Info("error get user: %w", zap.Error(err))
+		return
+	}
+
+	token, tokenHash, err := password.NewResetToken()
+	if err != nil {
+		zap.L().Info("error generate password reset token: %w", zap.Error(err))
+		return
+	}
+
+	if err := h.storage.CreatePasswordResetToken(ctx, user.ID, tokenHash, h.config.PasswordResetExpiry); err != nil {
+		zap.L().Info("error create password reset token: %w", zap.Error(err))
+		return
+	}
+
+	if err := h.notifier.SendPasswordReset(ctx, user.Login, token, h.config.PasswordResetExpiry); err != nil {
+		zap.L().Info("error send password reset token: %w", zap.Error(err))
+	}
+}
+
+func (h *Handler) ResetPassword(res http.ResponseWriter, req *http.Request) {
+	var requestModel models.ResetPasswordRequest
+	if err := json.NewDecoder(req.Body).Decode(&requestModel); err != nil || requestModel.Token == "" || requestModel.NewPassword == "" {
+		zap.L().Info("error validate reset password request", zap.Error(err))
+
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	passwordHash, err := password.Hash(requestModel.NewPassword)
+	if err != nil {
+		zap.L().Info("error hash password: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	user, err := h.storage.ResetUserPassword(req.Context(), password.HashResetToken(requestModel.Token), passwordHash)
+	if err != nil {
+		if errors.Is(err, storage.ErrNoRows) {
+			zap.L().Info("error password reset token is unknown, used or expired")
+
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		zap.L().Info("error reset password: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// The owner proved access to the login, failed attempts of whoever
+	// locked it out no longer count.
+	if err := h.loginGuard.Unlock(req.Context(), user.Login); err != nil {
+		zap.L().Info("error reset login failures: %w", zap.Error(err))
+	}
+
+	res.WriteHeader(http.StatusOK)
+}
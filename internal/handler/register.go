@@ -4,6 +4,8 @@ import (
 	"errors"
 	"net/http"
 
+	"github.com/VladKvetkin/gophermart/internal/entities"
+	"github.com/VladKvetkin/gophermart/internal/services/password"
 	"github.com/VladKvetkin/gophermart/internal/storage"
 	"go.uber.org/zap"
 )
@@ -17,7 +19,15 @@ func (h *Handler) Register(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	userID, err := h.storage.CreateUser(req.Context(), requestModel.Login, h.generatePasswordHash(requestModel.Password))
+	passwordHash, err := password.Hash(requestModel.Password)
+	if err != nil {
+		zap.L().Info("error hash password: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	userID, err := h.storage.CreateUser(req.Context(), requestModel.Login, passwordHash)
 	if err != nil {
 		if errors.Is(err, storage.ErrConflict) {
 			zap.L().Info("error login already exists: %w", zap.Error(err))
@@ -32,5 +42,5 @@ func (h *Handler) Register(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	h.generateTokenAndSetCookie(res, userID)
+	h.startSession(res, req, userID, entities.RoleUser)
 }
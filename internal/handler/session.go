@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/VladKvetkin/gophermart/internal/middleware"
+	"github.com/VladKvetkin/gophermart/internal/models"
+	"github.com/VladKvetkin/gophermart/internal/services/jwttoken"
+	"github.com/VladKvetkin/gophermart/internal/storage"
+	"go.uber.org/zap"
+)
+
+const (
+	RefreshTokenCookieName = "refresh_token"
+
+	// Refresh tokens are only sent to the endpoints under it.
+	refreshTokenCookiePath = "/api/user"
+)
+
+func (h *Handler) RefreshToken(res http.ResponseWriter, req *http.Request) {
+	currentRefreshToken := refreshTokenFromRequest(req)
+	if currentRefreshToken == "" {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	refreshToken, refreshTokenHash, err := jwttoken.NewRefreshToken()
+	if err != nil {
+		zap.L().Info("error generate refresh token: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	session, err := h.storage.RotateSession(
+		req.Context(),
+		jwttoken.HashRefreshToken(currentRefreshToken),
+		refreshTokenHash,
+		h.config.RefreshTokenExpiry,
+	)
+
+	if err != nil {
+		if errors.Is(err, storage.ErrNoRows) {
+			zap.L().Info("error refresh token of no active session")
+
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		zap.L().Info("error rotate session: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// The role is read again, so a refresh picks up role changes.
+	user, err := h.storage.GetUserByID(req.Context(), session.UserID)
+	if err != nil {
+		zap.L().Info("error get user: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	h.writeSession(res, req, jwttoken.Subject{UserID: user.ID, SessionID: session.ID, Role: user.Role}, refreshToken)
+}
+
+func (h *Handler) Logout(res http.ResponseWriter, req *http.Request) {
+	sessionID := h.getSessionIDFromReqContext(req)
+	if sessionID == "" {
+		res.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.storage.RevokeSession(req.Context(), sessionID); err != nil {
+		zap.L().Info("error revoke session: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(res, &http.Cookie{Name: middleware.TokenCookieName, Path: "/", MaxAge: -1})
+	http.SetCookie(res, &http.Cookie{Name: RefreshTokenCookieName, Path: refreshTokenCookiePath, MaxAge: -1, HttpOnly: true})
+
+	res.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) startSession(res http.ResponseWriter, req *http.Request, userID string, role string) {
+	refreshToken, refreshTokenHash, err := jwttoken.NewRefreshToken()
+	if err != nil {
+		zap.L().Info("error generate refresh token: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	session, err := h.storage.CreateSession(req.Context(), userID, refreshTokenHash, h.config.RefreshTokenExpiry)
+	if err != nil {
+		zap.L().Info("error create session: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	h.writeSession(res, req, jwttoken.Subject{UserID: userID, SessionID: session.ID, Role: role}, refreshToken)
+}
+
+// writeSession sets the token cookies and, for clients accepting JSON, also
+// returns the tokens in the body.
+func (h *Handler) writeSession(res http.ResponseWriter, req *http.Request, subject jwttoken.Subject, refreshToken string) {
+	accessToken, err := h.tokens.Generate(subject)
+	if err != nil {
+		zap.L().Info("error generate access token: %w", zap.Error(err))
+
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(res, &http.Cookie{
+		Name:  middleware.TokenCookieName,
+		Value: accessToken,
+		Path:  "/",
+	})
+
+	http.SetCookie(res, &http.Cookie{
+		Name:     RefreshTokenCookieName,
+		Value:    refreshToken,
+		Path:     refreshTokenCookiePath,
+		MaxAge:   int(h.config.RefreshTokenExpiry.Seconds()),
+		HttpOnly: true,
+	})
+
+	if !acceptsJSON(req) {
+		res.WriteHeader(http.StatusOK)
+		return
+	}
+
+	response := models.TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    middleware.BearerScheme,
+		ExpiresIn:    int(h.config.JWTExpiry.Seconds()),
+		RefreshToken: refreshToken,
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("Cache-Control", "no-store")
+	res.WriteHeader(http.StatusOK)
+
+	jsonEncoder := json.NewEncoder(res)
+	if err := jsonEncoder.Encode(response); err != nil {
+		zap.L().Info("cannot encode response JSON body: %w", zap.Error(err))
+	}
+}
+
+// refreshTokenFromRequest takes the refresh token from its cookie or, for
+// clients without cookies, from a JSON body.
+func refreshTokenFromRequest(req *http.Request) string {
+	if refreshCookie, err := req.Cookie(RefreshTokenCookieName); err == nil && refreshCookie.Value != "" {
+		return refreshCookie.Value
+	}
+
+	var request models.RefreshTokenRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		return ""
+	}
+
+	return request.RefreshToken
+}
+
+func acceptsJSON(req *http.Request) bool {
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == "application/json" {
+			return true
+		}
+	}
+
+	return false
+}
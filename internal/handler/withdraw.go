@@ -7,7 +7,7 @@ import (
 	"time"
 
 	"github.com/VladKvetkin/gophermart/internal/models"
-	"github.com/VladKvetkin/gophermart/internal/services/converter"
+	"github.com/VladKvetkin/gophermart/internal/money"
 	"github.com/VladKvetkin/gophermart/internal/services/validation"
 	"github.com/VladKvetkin/gophermart/internal/storage"
 	"go.uber.org/zap"
@@ -39,7 +39,7 @@ func (h *Handler) GetWithdrawals(res http.ResponseWriter, req *http.Request) {
 	for _, withdrawal := range withdrawals {
 		responseWithdrawal := models.WithdrawalResponse{
 			Number:    withdrawal.Number,
-			Withdrawn: converter.FormatAccrual(withdrawal.Withdrawn),
+			Withdrawn: money.FromCents(withdrawal.Withdrawn),
 			CreatedAt: withdrawal.CreatedAt.Format(time.RFC3339),
 		}
 
@@ -72,8 +72,8 @@ func (h *Handler) Withdraw(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if balanceWithdrawRequest.Withdrawn == 0 {
-		zap.L().Info("balance withdrawn request with sum=0")
+	if !balanceWithdrawRequest.Withdrawn.IsPositive() {
+		zap.L().Info("balance withdrawn request with non-positive sum")
 
 		res.WriteHeader(http.StatusBadRequest)
 		return
@@ -86,7 +86,7 @@ func (h *Handler) Withdraw(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if _, err := h.storage.CreateWithdraw(req.Context(), userID, balanceWithdrawRequest.OrderNumber, converter.ConvertAccrual(balanceWithdrawRequest.Withdrawn)); err != nil {
+	if _, err := h.storage.CreateWithdraw(req.Context(), userID, balanceWithdrawRequest.OrderNumber, balanceWithdrawRequest.Withdrawn.Cents()); err != nil {
 		if errors.Is(err, storage.ErrNotEnoughAccrual) {
 			zap.L().Info("error not enough user accrual for withdrawn: %w", zap.Error(err))
 
@@ -0,0 +1,124 @@
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/ShiraazMoollatjie/goluhn"
+	"github.com/VladKvetkin/gophermart/internal/config"
+	"github.com/VladKvetkin/gophermart/internal/entities"
+	"github.com/VladKvetkin/gophermart/internal/handler"
+	"github.com/VladKvetkin/gophermart/internal/middleware"
+	"github.com/VladKvetkin/gophermart/internal/models"
+	"github.com/VladKvetkin/gophermart/internal/money"
+	"github.com/VladKvetkin/gophermart/internal/services/notifier"
+	"github.com/VladKvetkin/gophermart/internal/storage"
+	"github.com/VladKvetkin/gophermart/internal/storage/storagetest"
+	"go.uber.org/zap"
+)
+
+func TestWithdrawConcurrent(t *testing.T) {
+	for name, newStorage := range map[string]storagetest.Factory{
+		"Memory":   storagetest.Memory,
+		"Postgres": storagetest.Postgres,
+	} {
+		newStorage := newStorage
+		t.Run(name, func(t *testing.T) {
+			testWithdrawConcurrent(t, newStorage(t))
+		})
+	}
+}
+
+// testWithdrawConcurrent sends many parallel withdrawals that together ask
+// for more than the balance: exactly as many as it covers must succeed.
+func testWithdrawConcurrent(t *testing.T, s storage.Storage) {
+	const (
+		accrual     = 1000_00
+		withdrawn   = 100_00
+		withdrawals = 50
+	)
+
+	ctx := context.Background()
+
+	userID, err := s.CreateUser(ctx, "user", "hash")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	order, _, err := s.GetOrCreateOrderIfNotExists(ctx, userID, orderNumber(t, 0))
+	if err != nil {
+		t.Fatalf("GetOrCreateOrderIfNotExists: %v", err)
+	}
+
+	if err := s.UpdateOrder(ctx, order, accrual, entities.OrderStatusProcessed); err != nil {
+		t.Fatalf("UpdateOrder: %v", err)
+	}
+
+	h := handler.NewHandler(config.Config{}, s, nil, nil, nil, notifier.NewLogNotifier(zap.NewNop()))
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		statuses = make(map[int]int)
+	)
+
+	for i := 1; i <= withdrawals; i++ {
+		body, err := json.Marshal(models.BalanceWithdrawRequest{OrderNumber: orderNumber(t, i), Withdrawn: money.FromCents(withdrawn)})
+		if err != nil {
+			t.Fatalf("encode request: %v", err)
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			req := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", bytes.NewReader(body))
+			req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey{}, userID))
+
+			res := httptest.NewRecorder()
+			h.Withdraw(res, req)
+
+			mu.Lock()
+			statuses[res.Code]++
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	if statuses[http.StatusOK] != accrual/withdrawn || statuses[http.StatusPaymentRequired] != withdrawals-accrual/withdrawn {
+		t.Fatalf("statuses of concurrent withdrawals: got %v, want %d OK and the rest %d", statuses, accrual/withdrawn, http.StatusPaymentRequired)
+	}
+
+	current, err := s.GetUserAccrual(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserAccrual: %v", err)
+	}
+
+	total, err := s.GetUserWithdrawn(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserWithdrawn: %v", err)
+	}
+
+	if current != 0 || total != accrual {
+		t.Fatalf("balance after concurrent withdrawals: got %d current and %d withdrawn, want 0 and %d", current, total, accrual)
+	}
+}
+
+func orderNumber(t *testing.T, i int) string {
+	t.Helper()
+
+	_, number, err := goluhn.Calculate(fmt.Sprintf("1234%06d", i))
+	if err != nil {
+		t.Fatalf("calculate order number: %v", err)
+	}
+
+	return number
+}
@@ -0,0 +1,127 @@
+package leader
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+var ErrLeadershipLost = errors.New("leadership lost")
+
+// Elector runs a job on a single instance at a time. Leadership is a session
+// level advisory lock held on a dedicated connection, so it is released by
+// Postgres as soon as the leader process or its connection dies.
+type Elector struct {
+	db       *sqlx.DB
+	lockKey  int64
+	interval time.Duration
+}
+
+func NewElector(db *sqlx.DB, lockKey int64, interval time.Duration) *Elector {
+	return &Elector{
+		db:       db,
+		lockKey:  lockKey,
+		interval: interval,
+	}
+}
+
+// Run tries to become the leader every interval and runs job while it is.
+// The job context is cancelled when the lock connection breaks, after which
+// Run competes for the lock again. Run returns when ctx is done or the job
+// fails on its own.
+func (e *Elector) Run(ctx context.Context, job func(context.Context) error) error {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		elected, err := e.runIfElected(ctx, job)
+		if err != nil && !errors.Is(err, ErrLeadershipLost) {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			if elected {
+				return err
+			}
+
+			zap.L().Info("error leader election: %w", zap.Error(err), zap.Int64("lockKey", e.lockKey))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (e *Elector) runIfElected(ctx context.Context, job func(context.Context) error) (bool, error) {
+	conn, err := e.db.Connx(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.GetContext(ctx, &locked, "SELECT pg_try_advisory_lock($1);", e.lockKey); err != nil {
+		return false, err
+	}
+
+	if !locked {
+		return false, nil
+	}
+
+	defer func() {
+		// The lock goes away with the connection anyway, unlocking just lets a
+		// standby take over without waiting for the pool to close it.
+		ctx, cancel := context.WithTimeout(context.Background(), e.interval)
+		defer cancel()
+
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1);", e.lockKey); err != nil {
+			zap.L().Info("error release leader lock: %w", zap.Error(err))
+
+			// Never give a connection that may still hold the lock back to the pool.
+			conn.Raw(func(any) error {
+				return driver.ErrBadConn
+			})
+		}
+	}()
+
+	zap.L().Info("elected as leader", zap.Int64("lockKey", e.lockKey))
+
+	jobCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	go e.watch(jobCtx, conn, cancel)
+
+	err = job(jobCtx)
+
+	if cause := context.Cause(jobCtx); errors.Is(cause, ErrLeadershipLost) {
+		zap.L().Info("leadership lost", zap.Int64("lockKey", e.lockKey), zap.Error(cause))
+		return true, ErrLeadershipLost
+	}
+
+	return true, err
+}
+
+func (e *Elector) watch(ctx context.Context, conn *sqlx.Conn, cancel context.CancelCauseFunc) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := conn.ExecContext(ctx, "SELECT 1;"); err != nil && ctx.Err() == nil {
+				cancel(errors.Join(ErrLeadershipLost, err))
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
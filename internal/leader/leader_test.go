@@ -0,0 +1,187 @@
+package leader_test
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VladKvetkin/gophermart/internal/leader"
+	"github.com/VladKvetkin/gophermart/internal/storage/storagetest"
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+)
+
+const (
+	interval    = 50 * time.Millisecond
+	waitTimeout = 5 * time.Second
+)
+
+// jobs records which electors run their job and how many at once.
+type jobs struct {
+	mu        sync.Mutex
+	running   map[string]bool
+	maxAtOnce int
+}
+
+func (j *jobs) job(name string) func(context.Context) error {
+	return func(ctx context.Context) error {
+		j.mu.Lock()
+		j.running[name] = true
+		if len(j.running) > j.maxAtOnce {
+			j.maxAtOnce = len(j.running)
+		}
+		j.mu.Unlock()
+
+		<-ctx.Done()
+
+		j.mu.Lock()
+		delete(j.running, name)
+		j.mu.Unlock()
+
+		return ctx.Err()
+	}
+}
+
+func (j *jobs) isRunning(name string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.running[name]
+}
+
+func (j *jobs) atOnce() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.maxAtOnce
+}
+
+// leader returns the elector running the job, any of them when a lost
+// leader has not stopped yet.
+func (j *jobs) leader() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	for name := range j.running {
+		return name
+	}
+
+	return ""
+}
+
+// TestElector runs two electors for the same lock the way two replicas do:
+// only one of them may run the job, and the other takes over once the
+// connection of the leader is gone. It skips unless
+// GOPHERMART_TEST_DATABASE_URI points at a Postgres instance.
+func TestElector(t *testing.T) {
+	admin := connect(t, "")
+	lockKey := rand.Int63()
+
+	j := &jobs{running: make(map[string]bool)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer cancel()
+
+	var (
+		applicationNames = make(map[string]string)
+		dbs              = make(map[string]*sqlx.DB)
+	)
+
+	for _, name := range []string{"first", "second"} {
+		applicationNames[name] = "leadertest_" + name + "_" + time.Now().Format("150405.000000")
+		dbs[name] = connect(t, applicationNames[name])
+		elector := leader.NewElector(dbs[name], lockKey, interval)
+
+		name := name
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if err := elector.Run(ctx, j.job(name)); err != nil && !errors.Is(err, context.Canceled) {
+				t.Errorf("Run of the %s elector: %v", name, err)
+			}
+		}()
+	}
+
+	waitFor(t, "a leader", func() bool { return j.leader() != "" })
+
+	// Both electors try again every interval meanwhile.
+	time.Sleep(10 * interval)
+
+	if atOnce := j.atOnce(); atOnce != 1 {
+		t.Fatalf("jobs running at once: got %d, want 1", atOnce)
+	}
+
+	first := j.leader()
+
+	// The replica of the leader dies: its pool is gone for good and its
+	// connections are dropped.
+	dbs[first].Close()
+
+	if _, err := admin.Exec(
+		"SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE application_name = $1;",
+		applicationNames[first],
+	); err != nil {
+		t.Fatalf("terminate the connections of the leader: %v", err)
+	}
+
+	// The standby may get the lock before the lost leader notices, within
+	// an interval.
+	waitFor(t, "the lost leader to stop", func() bool { return !j.isRunning(first) })
+	waitFor(t, "the standby to take over", func() bool {
+		leader := j.leader()
+		return leader != "" && leader != first
+	})
+}
+
+func connect(t *testing.T, applicationName string) *sqlx.DB {
+	t.Helper()
+
+	databaseURI := os.Getenv(storagetest.DatabaseURIEnv)
+	if databaseURI == "" {
+		t.Skipf("%s is not set", storagetest.DatabaseURIEnv)
+	}
+
+	if applicationName != "" {
+		u, err := url.Parse(databaseURI)
+		if err != nil {
+			t.Fatalf("parse %s: %v", storagetest.DatabaseURIEnv, err)
+		}
+
+		query := u.Query()
+		query.Set("application_name", applicationName)
+		u.RawQuery = query.Encode()
+		databaseURI = u.String()
+	}
+
+	db, err := sqlx.Connect("postgres", databaseURI)
+	if err != nil {
+		t.Fatalf("connect to %s: %v", storagetest.DatabaseURIEnv, err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(waitTimeout)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,16 @@
+package logger
+
+import "go.uber.org/zap"
+
+// Initialize replaces the no-op global logger returned by zap.L() with a
+// production logger writing JSON to stderr.
+func Initialize() error {
+	logger, err := zap.NewProduction()
+	if err != nil {
+		return err
+	}
+
+	zap.ReplaceGlobals(logger)
+
+	return nil
+}
@@ -2,36 +2,103 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"strings"
 
+	"github.com/VladKvetkin/gophermart/internal/entities"
 	"github.com/VladKvetkin/gophermart/internal/services/jwttoken"
+	"github.com/VladKvetkin/gophermart/internal/storage"
+	"go.uber.org/zap"
 )
 
 type UserIDKey struct{}
 
-const TokenCookieName = "token"
+type SessionIDKey struct{}
 
-func Auth(next http.Handler) http.Handler {
+type RoleKey struct{}
+
+const (
+	TokenCookieName = "token"
+	BearerScheme    = "Bearer"
+)
+
+type Sessions interface {
+	GetActiveSession(context.Context, string) (entities.Session, error)
+}
+
+// Auth accepts valid access tokens of sessions that are neither revoked nor
+// expired, sent either as a bearer token or in the token cookie.
+func Auth(tokens *jwttoken.Manager, sessions Sessions) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return auth(tokens, sessions, next)
+	}
+}
+
+func auth(tokens *jwttoken.Manager, sessions Sessions, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
-		tokenCookie, err := req.Cookie(TokenCookieName)
+		accessToken, ok := accessTokenFromRequest(req)
+		if !ok {
+			unauthorized(resp)
+			return
+		}
+
+		subject, err := tokens.Parse(accessToken)
 		if err != nil {
-			if err == http.ErrNoCookie {
-				resp.WriteHeader(http.StatusUnauthorized)
+			unauthorized(resp)
+			return
+		}
+
+		session, err := sessions.GetActiveSession(req.Context(), subject.SessionID)
+		if err != nil {
+			if errors.Is(err, storage.ErrNoRows) {
+				unauthorized(resp)
 				return
 			}
 
+			zap.L().Info("error get session: %w", zap.Error(err))
+
 			resp.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		userID, err := jwttoken.Parse(tokenCookie.Value)
-		if err != nil {
-			resp.WriteHeader(http.StatusUnauthorized)
+		if session.UserID != subject.UserID {
+			unauthorized(resp)
 			return
 		}
 
-		req = req.WithContext(context.WithValue(req.Context(), UserIDKey{}, userID))
+		ctx := context.WithValue(req.Context(), UserIDKey{}, subject.UserID)
+		ctx = context.WithValue(ctx, SessionIDKey{}, subject.SessionID)
+		ctx = context.WithValue(ctx, RoleKey{}, subject.Role)
 
-		next.ServeHTTP(resp, req)
+		next.ServeHTTP(resp, req.WithContext(ctx))
 	})
 }
+
+// accessTokenFromRequest prefers the Authorization header. A request that
+// has the header but not a bearer token in it is not authorized even with
+// the cookie set, so clients never get a session they did not ask for.
+func accessTokenFromRequest(req *http.Request) (string, bool) {
+	if header := req.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, BearerScheme) {
+			return "", false
+		}
+
+		token = strings.TrimSpace(token)
+
+		return token, token != ""
+	}
+
+	tokenCookie, err := req.Cookie(TokenCookieName)
+	if err != nil || tokenCookie.Value == "" {
+		return "", false
+	}
+
+	return tokenCookie.Value, true
+}
+
+func unauthorized(resp http.ResponseWriter) {
+	resp.Header().Set("WWW-Authenticate", BearerScheme)
+	resp.WriteHeader(http.StatusUnauthorized)
+}
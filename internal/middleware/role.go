@@ -0,0 +1,26 @@
+package middleware
+
+import "net/http"
+
+// RequireRole lets through requests of users with one of roles, it must be
+// used after Auth.
+func RequireRole(roles ...string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+			role, ok := req.Context().Value(RoleKey{}).(string)
+			if !ok {
+				unauthorized(resp)
+				return
+			}
+
+			for _, allowed := range roles {
+				if role == allowed {
+					next.ServeHTTP(resp, req)
+					return
+				}
+			}
+
+			resp.WriteHeader(http.StatusForbidden)
+		})
+	}
+}
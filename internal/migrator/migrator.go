@@ -0,0 +1,340 @@
+package migrator
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Every migration runs under this advisory lock, so replicas starting at the
+// same time apply each version exactly once.
+const lockKey = 7226410843
+
+var (
+	ErrSchemaTooNew = errors.New("database schema is newer than the binary")
+	ErrNoApplied    = errors.New("no applied migrations")
+
+	fileNameRe = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Version   int          `db:"version"`
+	Name      string       `db:"name"`
+	AppliedAt sql.NullTime `db:"applied_at"`
+}
+
+type Migrator struct {
+	db         *sqlx.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *sqlx.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := loadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+	}, nil
+}
+
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	if err := m.ensureVersionTable(ctx); err != nil {
+		return 0, err
+	}
+
+	return m.version(ctx, m.db)
+}
+
+func (m *Migrator) CheckVersion(ctx context.Context) error {
+	version, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	if version > m.Latest() {
+		return fmt.Errorf("%w: database is at %d, binary knows up to %d", ErrSchemaTooNew, version, m.Latest())
+	}
+
+	return nil
+}
+
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	if err := m.CheckVersion(ctx); err != nil {
+		return nil, err
+	}
+
+	var applied []Migration
+
+	for _, migration := range m.migrations {
+		ok, err := m.apply(ctx, migration)
+		if err != nil {
+			return applied, fmt.Errorf("error applying migration %d_%s: %w", migration.Version, migration.Name, err)
+		}
+
+		if ok {
+			applied = append(applied, migration)
+		}
+	}
+
+	return applied, nil
+}
+
+func (m *Migrator) Down(ctx context.Context) (Migration, error) {
+	if err := m.CheckVersion(ctx); err != nil {
+		return Migration{}, err
+	}
+
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return Migration{}, err
+	}
+
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1);", lockKey); err != nil {
+		return Migration{}, err
+	}
+
+	version, err := m.version(ctx, tx)
+	if err != nil {
+		return Migration{}, err
+	}
+
+	if version == 0 {
+		return Migration{}, ErrNoApplied
+	}
+
+	migration, ok := m.find(version)
+	if !ok {
+		return Migration{}, fmt.Errorf("unknown applied migration %d", version)
+	}
+
+	if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+		return Migration{}, fmt.Errorf("error reverting migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1;", version); err != nil {
+		return Migration{}, err
+	}
+
+	return migration, tx.Commit()
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	if err := m.ensureVersionTable(ctx); err != nil {
+		return nil, err
+	}
+
+	var applied []Status
+
+	if err := m.db.SelectContext(
+		ctx,
+		&applied,
+		"SELECT version, name, applied_at FROM schema_migrations ORDER BY version ASC;",
+	); err != nil {
+		return nil, err
+	}
+
+	appliedAt := make(map[int]Status, len(applied))
+	for _, status := range applied {
+		appliedAt[status.Version] = status
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if a, ok := appliedAt[migration.Version]; ok {
+			status.AppliedAt = a.AppliedAt
+			delete(appliedAt, migration.Version)
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	// Versions applied by a newer binary are still reported.
+	for _, status := range appliedAt {
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+
+	return statuses, nil
+}
+
+func (m *Migrator) apply(ctx context.Context, migration Migration) (bool, error) {
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1);", lockKey); err != nil {
+		return false, err
+	}
+
+	var exists bool
+	if err := tx.GetContext(ctx, &exists, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1);", migration.Version); err != nil {
+		return false, err
+	}
+
+	if exists {
+		return false, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+		return false, err
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		"INSERT INTO schema_migrations (version, name) VALUES ($1, $2);",
+		migration.Version, migration.Name,
+	); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// ensureVersionTable takes the migration lock too, parallel CREATE TABLE IF
+// NOT EXISTS statements may still fail on each other.
+func (m *Migrator) ensureVersionTable(ctx context.Context) error {
+	tx, err := m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1);", lockKey); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`
+		CREATE TABLE IF NOT EXISTS schema_migrations(
+			version INT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		`,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (m *Migrator) version(ctx context.Context, q sqlx.QueryerContext) (int, error) {
+	var version int
+
+	if err := sqlx.GetContext(ctx, q, &version, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations;"); err != nil {
+		return 0, err
+	}
+
+	return version, nil
+}
+
+func (m *Migrator) find(version int) (Migration, bool) {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+
+	return Migration{}, false
+}
+
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		matches := fileNameRe.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			continue
+		}
+
+		version, err := strconv.Atoi(matches[1])
+		if err != nil {
+			return nil, err
+		}
+
+		if version <= 0 {
+			return nil, fmt.Errorf("invalid migration version in %s", entry.Name())
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = migration
+		}
+
+		if migration.Name != matches[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %s and %s", version, migration.Name, matches[2])
+		}
+
+		if matches[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down files", migration.Version, migration.Name)
+		}
+
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
@@ -0,0 +1,157 @@
+package migrator_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/VladKvetkin/gophermart/internal/migrator"
+	"github.com/VladKvetkin/gophermart/internal/storage/migrations"
+	"github.com/VladKvetkin/gophermart/internal/storage/storagetest"
+)
+
+// TestUpDownUp applies every migration of the service, reverts them one by
+// one and applies them again. It skips unless GOPHERMART_TEST_DATABASE_URI
+// points at a Postgres instance, as do the other tests here.
+func TestUpDownUp(t *testing.T) {
+	ctx := context.Background()
+
+	m, err := migrator.NewMigrator(storagetest.PostgresDB(t), migrations.FS)
+	if err != nil {
+		t.Fatalf("NewMigrator: %v", err)
+	}
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+
+	assertUp(t, m, len(statuses))
+
+	for version := m.Latest(); version > 0; {
+		migration, err := m.Down(ctx)
+		if err != nil {
+			t.Fatalf("Down from %d: %v", version, err)
+		}
+
+		if migration.Version != version {
+			t.Fatalf("Down: got %d reverted, want %d", migration.Version, version)
+		}
+
+		if version, err = m.Version(ctx); err != nil {
+			t.Fatalf("Version: %v", err)
+		}
+	}
+
+	if _, err := m.Down(ctx); !errors.Is(err, migrator.ErrNoApplied) {
+		t.Fatalf("Down with nothing applied: got %v, want %v", err, migrator.ErrNoApplied)
+	}
+
+	assertUp(t, m, len(statuses))
+	assertUp(t, m, 0)
+}
+
+// TestUpConcurrent starts migrators at once the way replicas do: every
+// migration must be applied exactly once.
+func TestUpConcurrent(t *testing.T) {
+	const migrators = 5
+
+	db := storagetest.PostgresDB(t)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		applied int
+	)
+
+	for i := 0; i < migrators; i++ {
+		m, err := migrator.NewMigrator(db, migrations.FS)
+		if err != nil {
+			t.Fatalf("NewMigrator: %v", err)
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			done, err := m.Up(context.Background())
+			if err != nil {
+				t.Errorf("Up: %v", err)
+				return
+			}
+
+			mu.Lock()
+			applied += len(done)
+			mu.Unlock()
+		}()
+	}
+
+	wg.Wait()
+
+	m, err := migrator.NewMigrator(db, migrations.FS)
+	if err != nil {
+		t.Fatalf("NewMigrator: %v", err)
+	}
+
+	statuses, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+
+	if applied != len(statuses) {
+		t.Fatalf("concurrent Up: got %d migrations applied, want each of %d once", applied, len(statuses))
+	}
+}
+
+// TestOrder checks that versions are applied by number, not by file name,
+// and reverted the other way round.
+func TestOrder(t *testing.T) {
+	ctx := context.Background()
+
+	fsys := fstest.MapFS{
+		"1_create.up.sql":   {Data: []byte("CREATE TABLE items(id INT);")},
+		"1_create.down.sql": {Data: []byte("DROP TABLE items;")},
+		"2_add.up.sql":      {Data: []byte("ALTER TABLE items ADD COLUMN name TEXT;")},
+		"2_add.down.sql":    {Data: []byte("ALTER TABLE items DROP COLUMN name;")},
+		"10_index.up.sql":   {Data: []byte("CREATE INDEX items_name_idx ON items(name);")},
+		"10_index.down.sql": {Data: []byte("DROP INDEX items_name_idx;")},
+	}
+
+	m, err := migrator.NewMigrator(storagetest.PostgresDB(t), fsys)
+	if err != nil {
+		t.Fatalf("NewMigrator: %v", err)
+	}
+
+	if m.Latest() != 10 {
+		t.Fatalf("Latest: got %d, want 10", m.Latest())
+	}
+
+	assertUp(t, m, 3)
+
+	for _, want := range []int{10, 2, 1} {
+		migration, err := m.Down(ctx)
+		if err != nil {
+			t.Fatalf("Down: %v", err)
+		}
+
+		if migration.Version != want {
+			t.Fatalf("Down: got %d reverted, want %d", migration.Version, want)
+		}
+	}
+}
+
+func assertUp(t *testing.T, m *migrator.Migrator, want int) {
+	t.Helper()
+
+	applied, err := m.Up(context.Background())
+	if err != nil {
+		t.Fatalf("Up: %v", err)
+	}
+
+	if len(applied) != want {
+		t.Fatalf("Up: got %d migrations applied, want %d", len(applied), want)
+	}
+}
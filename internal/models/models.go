@@ -1,39 +1,75 @@
 package models
 
+import "github.com/VladKvetkin/gophermart/internal/money"
+
 type AuthorizationRequst struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
 }
 
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type PasswordResetRequest struct {
+	Login string `json:"login"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+type SetRoleRequest struct {
+	Role string `json:"role"`
+}
+
 type BalanceWithdrawRequest struct {
-	OrderNumber string  `json:"order"`
-	Withdrawn   float64 `json:"sum"`
+	OrderNumber string       `json:"order"`
+	Withdrawn   money.Amount `json:"sum"`
 }
 
 type GetOrdersReponse []OrderResponse
 
 type OrderResponse struct {
-	Number     string  `json:"number"`
-	Status     string  `json:"status"`
-	Accrual    float64 `json:"accrual,omitempty"`
-	UploadedAt string  `json:"uploaded_at"`
+	Number     string       `json:"number"`
+	Status     string       `json:"status"`
+	Accrual    money.Amount `json:"accrual,omitempty"`
+	UploadedAt string       `json:"uploaded_at"`
 }
 
 type GetBalanceResponse struct {
-	Accrual   float64 `json:"current"`
-	Withdranw float64 `json:"withdrawn"`
+	Accrual   money.Amount `json:"current"`
+	Withdranw money.Amount `json:"withdrawn"`
 }
 
 type GetWithdrawalsResponse []WithdrawalResponse
 
 type WithdrawalResponse struct {
-	Number    string  `json:"order"`
-	Withdrawn float64 `json:"sum"`
-	CreatedAt string  `json:"processed_at"`
+	Number    string       `json:"order"`
+	Withdrawn money.Amount `json:"sum"`
+	CreatedAt string       `json:"processed_at"`
 }
 
 type AccrualAPIGetOrderResponse struct {
-	Number  string  `json:"order"`
-	Status  string  `json:"status"`
-	Accrual float64 `json:"accrual,omitempty"`
+	Number  string       `json:"order"`
+	Status  string       `json:"status"`
+	Accrual money.Amount `json:"accrual,omitempty"`
+}
+
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type HealthResponse struct {
+	Status  string `json:"status"`
+	Accrual string `json:"accrual"`
 }
@@ -0,0 +1,191 @@
+package money
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Scale is the number of fractional digits an Amount keeps.
+const Scale = 2
+
+// Any Amount fits in this many digits of cents.
+const maxDigits = 19
+
+var (
+	ErrInvalid  = errors.New("invalid amount")
+	ErrOverflow = errors.New("amount out of range")
+)
+
+// Amount is an exact sum of money in cents. Values with more fractional
+// digits than Scale are rounded half away from zero: 0.125 is 0.13 and
+// -0.125 is -0.13.
+type Amount int64
+
+func FromCents(cents int) Amount {
+	return Amount(cents)
+}
+
+func (a Amount) Cents() int {
+	return int(a)
+}
+
+func (a Amount) IsPositive() bool {
+	return a > 0
+}
+
+// Percent returns percent per cent of a, rounded half away from zero.
+func (a Amount) Percent(percent Amount) Amount {
+	product := int64(a) * int64(percent)
+
+	quotient, remainder := product/10000, product%10000
+	if remainder >= 5000 {
+		quotient++
+	} else if remainder <= -5000 {
+		quotient--
+	}
+
+	return Amount(quotient)
+}
+
+// Parse reads a decimal number, exponent included, the way it is written in
+// JSON. It never goes through a float.
+func Parse(s string) (Amount, error) {
+	value := s
+
+	negative := strings.HasPrefix(value, "-")
+	if negative {
+		value = value[1:]
+	}
+
+	exponent := 0
+	if i := strings.IndexAny(value, "eE"); i >= 0 {
+		e, err := strconv.Atoi(value[i+1:])
+		if err != nil {
+			return 0, fmt.Errorf("%w %q", ErrInvalid, s)
+		}
+
+		// Anything further out would overflow or round to zero, and
+		// could overflow the shift below.
+		if e > maxDigits+Scale || e < -(maxDigits+Scale) {
+			return 0, fmt.Errorf("%w %q", ErrOverflow, s)
+		}
+
+		exponent, value = e, value[:i]
+	}
+
+	integer, fraction, _ := strings.Cut(value, ".")
+	if (integer == "" && fraction == "") || !isDigits(integer) || !isDigits(fraction) {
+		return 0, fmt.Errorf("%w %q", ErrInvalid, s)
+	}
+
+	digits := strings.TrimLeft(integer+fraction, "0")
+
+	// digits * 10^shift is the amount in cents.
+	shift := Scale - len(fraction) + exponent
+
+	var roundUp bool
+
+	switch {
+	case digits == "":
+		return 0, nil
+	case shift > 0:
+		if len(digits)+shift > maxDigits {
+			return 0, fmt.Errorf("%w %q", ErrOverflow, s)
+		}
+
+		digits += strings.Repeat("0", shift)
+	case shift < 0:
+		drop := -shift
+		if drop > len(digits) {
+			return 0, nil
+		}
+
+		roundUp = digits[len(digits)-drop] >= '5'
+		digits = digits[:len(digits)-drop]
+	}
+
+	if len(digits) > maxDigits {
+		return 0, fmt.Errorf("%w %q", ErrOverflow, s)
+	}
+
+	var cents uint64
+
+	if digits != "" {
+		parsed, err := strconv.ParseUint(digits, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w %q", ErrOverflow, s)
+		}
+
+		cents = parsed
+	}
+
+	if roundUp {
+		cents++
+	}
+
+	// The magnitude of the smallest Amount is one more than of the largest.
+	if cents > math.MaxInt64 && !(negative && cents == math.MaxInt64+1) {
+		return 0, fmt.Errorf("%w %q", ErrOverflow, s)
+	}
+
+	if negative {
+		return Amount(-cents), nil
+	}
+
+	return Amount(cents), nil
+}
+
+// String formats a without trailing fractional zeros, e.g. 700, 379.5 or
+// 0.29.
+func (a Amount) String() string {
+	var (
+		sign  string
+		cents = uint64(a)
+	)
+
+	if a < 0 {
+		sign, cents = "-", -cents
+	}
+
+	integer := strconv.FormatUint(cents/100, 10)
+
+	fraction := strings.TrimRight(fmt.Sprintf("%02d", cents%100), "0")
+	if fraction == "" {
+		return sign + integer
+	}
+
+	return sign + integer + "." + fraction
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+
+	amount, err := Parse(string(data))
+	if err != nil {
+		return err
+	}
+
+	*a = amount
+
+	return nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,175 @@
+package money_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/quick"
+
+	"github.com/VladKvetkin/gophermart/internal/money"
+)
+
+func TestAmount(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T)
+	}{
+		{"StringRoundTrip", testStringRoundTrip},
+		{"JSONRoundTrip", testJSONRoundTrip},
+		{"ParseCents", testParseCents},
+		{"ParseExponent", testParseExponent},
+		{"ParseRounding", testParseRounding},
+		{"ParseKnownValues", testParseKnownValues},
+		{"ParseInvalid", testParseInvalid},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, tt.run)
+	}
+}
+
+func check(t *testing.T, property any) {
+	t.Helper()
+
+	if err := quick.Check(property, &quick.Config{MaxCount: 10000}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testStringRoundTrip(t *testing.T) {
+	check(t, func(cents int64) bool {
+		amount, err := money.Parse(money.Amount(cents).String())
+		return err == nil && amount == money.Amount(cents)
+	})
+}
+
+func testJSONRoundTrip(t *testing.T) {
+	type document struct {
+		Sum money.Amount `json:"sum"`
+	}
+
+	check(t, func(cents int64) bool {
+		data, err := json.Marshal(document{Sum: money.Amount(cents)})
+		if err != nil {
+			return false
+		}
+
+		var decoded document
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			return false
+		}
+
+		return decoded.Sum == money.Amount(cents)
+	})
+}
+
+// Any number with at most two fractional digits is parsed exactly, however
+// its zeros are written.
+func testParseCents(t *testing.T) {
+	check(t, func(integer uint32, fraction uint8, leading uint8, trailing uint8, negative bool) bool {
+		cents := int64(integer)*100 + int64(fraction%100)
+
+		s := strings.Repeat("0", int(leading%3)) + fmt.Sprintf("%d.%02d", integer, fraction%100) + strings.Repeat("0", int(trailing%5))
+		if negative {
+			s, cents = "-"+s, -cents
+		}
+
+		amount, err := money.Parse(s)
+		return err == nil && amount == money.Amount(cents)
+	})
+}
+
+func testParseExponent(t *testing.T) {
+	check(t, func(cents int32, exponent uint8) bool {
+		shift := int(exponent % 10)
+
+		s := fmt.Sprintf("%d%se%d", cents, strings.Repeat("0", shift), -shift-money.Scale)
+
+		amount, err := money.Parse(s)
+		return err == nil && amount == money.Amount(cents)
+	})
+}
+
+// Digits past the second fractional one round half away from zero.
+func testParseRounding(t *testing.T) {
+	check(t, func(cents int32, rest uint16) bool {
+		tail := fmt.Sprintf("%04d", rest%10000)
+
+		want := int64(math.Abs(float64(cents)))
+		if tail[0] >= '5' {
+			want++
+		}
+
+		sign := ""
+		if cents < 0 {
+			sign, want = "-", -want
+		}
+
+		abs := int64(math.Abs(float64(cents)))
+		s := fmt.Sprintf("%s%d.%02d%s", sign, abs/100, abs%100, tail)
+
+		amount, err := money.Parse(s)
+		return err == nil && amount == money.Amount(want)
+	})
+}
+
+func testParseKnownValues(t *testing.T) {
+	tests := map[string]money.Amount{
+		"0.29":        29,
+		"0.57":        57,
+		"1.005":       101,
+		"-1.005":      -101,
+		"1.0049":      100,
+		"700":         70000,
+		"379.5":       37950,
+		"1e3":         100000,
+		"1.5E-1":      15,
+		"0.00000001":  0,
+		"-0":          0,
+		"12345678.91": 1234567891,
+	}
+
+	for s, want := range tests {
+		amount, err := money.Parse(s)
+		if err != nil || amount != want {
+			t.Errorf("Parse(%q): got %v, %v, want %v", s, amount, err, want)
+		}
+	}
+
+	for _, cents := range []int64{0, 1, 10, 100, -1, -10, math.MaxInt64, math.MinInt64} {
+		if amount, err := money.Parse(money.Amount(cents).String()); err != nil || amount != money.Amount(cents) {
+			t.Errorf("Parse(%q): got %v, %v, want %d cents", money.Amount(cents).String(), amount, err, cents)
+		}
+	}
+}
+
+func testParseInvalid(t *testing.T) {
+	for _, s := range []string{"", "-", ".", "abc", "1.2.3", "1e", "--1", "+1", "1,5", `"1"`, "92233720368547758.08", "-92233720368547758.09", "1e100", "1e9223372036854775805", "1e9223372036854775806", "1e9223372036854775807"} {
+		if amount, err := money.Parse(s); err == nil {
+			t.Errorf("Parse(%q): got %v, want an error", s, amount)
+		}
+	}
+
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		b := make([]byte, 1+random.Intn(8))
+		for j := range b {
+			b[j] = "0123456789.-eE+x "[random.Intn(17)]
+		}
+
+		// Whatever the input, Parse either fails or returns a value that
+		// formats back to itself.
+		amount, err := money.Parse(string(b))
+		if err != nil {
+			continue
+		}
+
+		if again, err := money.Parse(amount.String()); err != nil || again != amount {
+			t.Errorf("Parse(%q) = %v does not round trip", b, amount)
+		}
+	}
+}
@@ -0,0 +1,570 @@
+package server_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VladKvetkin/gophermart/internal/accrualer"
+	"github.com/VladKvetkin/gophermart/internal/accrualsim"
+	"github.com/VladKvetkin/gophermart/internal/config"
+	"github.com/VladKvetkin/gophermart/internal/models"
+	"github.com/VladKvetkin/gophermart/internal/money"
+	"github.com/VladKvetkin/gophermart/internal/server"
+	"github.com/VladKvetkin/gophermart/internal/services/jwttoken"
+	"github.com/VladKvetkin/gophermart/internal/storage"
+	"github.com/VladKvetkin/gophermart/internal/storage/storagetest"
+)
+
+// processingTimeout bounds the wait for the accrualer to bring an order to
+// its final status.
+const processingTimeout = 10 * time.Second
+
+// defaultClientIP is where clients log in from unless a journey moves them.
+const defaultClientIP = "192.0.2.1"
+
+func TestMemoryServer(t *testing.T) {
+	runJourneys(t, storagetest.Memory)
+}
+
+// TestPostgresServer skips every journey unless GOPHERMART_TEST_DATABASE_URI
+// points at a Postgres instance.
+func TestPostgresServer(t *testing.T) {
+	runJourneys(t, storagetest.Postgres)
+}
+
+// runJourneys boots the server and the accrualer on random ports for every
+// journey, each with a fresh storage from newStorage and its own accrual
+// simulator.
+func runJourneys(t *testing.T, newStorage storagetest.Factory) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, env *Env)
+	}{
+		{"Auth", testAuth},
+		{"Sessions", testSessions},
+		{"BearerTokens", testBearerTokens},
+		{"Orders", testOrders},
+		{"Balance", testBalance},
+		{"InvalidOrder", testInvalidOrder},
+		{"LoginLockout", testLoginLockout},
+		{"ChangePassword", testChangePassword},
+		{"PasswordReset", testPasswordReset},
+		{"Admin", testAdmin},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, NewEnv(t, newStorage))
+		})
+	}
+}
+
+type Env struct {
+	URL        string
+	AccrualURL string
+	Mailbox    *Mailbox
+
+	storage   storage.Storage
+	transport *http.Transport
+}
+
+func NewEnv(t *testing.T, newStorage storagetest.Factory) *Env {
+	t.Helper()
+
+	accrualServer := httptest.NewServer(
+		accrualsim.NewHandler(accrualsim.Config{}, accrualsim.NewSimulator(100*time.Millisecond)).Routes(),
+	)
+	t.Cleanup(accrualServer.Close)
+
+	config := config.Config{
+		Address:                   freeAddress(t),
+		AccrualSystemAddress:      accrualServer.URL,
+		LeaderElectionInterval:    time.Second,
+		JWTKeys:                   "e2e:" + strings.Repeat("k", 32),
+		JWTExpiry:                 time.Hour,
+		RefreshTokenExpiry:        time.Hour,
+		JWTIssuer:                 "gophermart",
+		JWTAudience:               "gophermart",
+		AccrualPollInterval:       50 * time.Millisecond,
+		AccrualRequestTimeout:     time.Second,
+		AccrualMaxAttempts:        20,
+		AccrualRetryBase:          50 * time.Millisecond,
+		AccrualRetryMax:           time.Second,
+		AccrualBreakerFailures:    5,
+		AccrualBreakerOpenTimeout: time.Second,
+		LoginMaxFailures:          3,
+		LoginIPMaxFailures:        10,
+		LoginClientIPHeader:       "X-Forwarded-For",
+		LoginLockoutBase:          time.Minute,
+		LoginLockoutMax:           time.Hour,
+		PasswordResetExpiry:       time.Hour,
+		PasswordResetMaxRequests:  3,
+	}
+
+	tokens, err := jwttoken.NewManager(config)
+	if err != nil {
+		t.Fatalf("create token manager: %v", err)
+	}
+
+	dataStorage := newStorage(t)
+	accrualer := accrualer.NewAccrualer(config, dataStorage, accrualer.NewHTTPClient(config.AccrualSystemAddress, config.AccrualRequestTimeout))
+	mailbox := NewMailbox()
+	server := server.NewServer(config, dataStorage, tokens, accrualer, accrualer, mailbox)
+
+	env := &Env{
+		URL:        "http://" + config.Address,
+		AccrualURL: accrualServer.URL,
+		Mailbox:    mailbox,
+		storage:    dataStorage,
+		transport:  http.DefaultTransport.(*http.Transport).Clone(),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	accrualerDone := make(chan struct{})
+	go func() {
+		defer close(accrualerDone)
+		accrualer.Start(ctx)
+	}()
+
+	go server.Start()
+
+	t.Cleanup(func() {
+		cancel()
+		<-accrualerDone
+
+		// Shutdown waits for connections the transport dialed but never used.
+		env.transport.CloseIdleConnections()
+
+		if err := server.Stop(); err != nil {
+			t.Errorf("stop server: %v", err)
+		}
+	})
+
+	env.waitReady(t)
+
+	return env
+}
+
+// AddRewardRule registers a reward rule in the accrual simulator.
+func (e *Env) AddRewardRule(t *testing.T, rule accrualsim.RewardRule) {
+	t.Helper()
+
+	response := do(t, e.client(), http.MethodPost, e.AccrualURL+"/api/goods", "application/json", jsonBody(t, rule))
+	expectStatus(t, response, http.StatusOK)
+}
+
+// GrantRole sets the role of login directly in storage, the way the
+// create-admin command bootstraps the first admin.
+func (e *Env) GrantRole(t *testing.T, login string, role string) {
+	t.Helper()
+
+	user, err := e.storage.GetUserByLogin(context.Background(), login)
+	if err != nil {
+		t.Fatalf("get user %s: %v", login, err)
+	}
+
+	if err := e.storage.SetUserRole(context.Background(), user.ID, role); err != nil {
+		t.Fatalf("set role of %s: %v", login, err)
+	}
+}
+
+// RegisterAccrualOrder registers an order with its goods in the accrual
+// simulator.
+func (e *Env) RegisterAccrualOrder(t *testing.T, number string, goods ...accrualsim.Good) {
+	t.Helper()
+
+	request := accrualsim.RegisterOrderRequest{Number: number, Goods: goods}
+
+	response := do(t, e.client(), http.MethodPost, e.AccrualURL+"/api/orders", "application/json", jsonBody(t, request))
+	expectStatus(t, response, http.StatusAccepted)
+}
+
+// Client keeps the session of a single user, in cookies or, for bearer
+// clients, in the tokens returned in JSON bodies.
+type Client struct {
+	env      *Env
+	jar      http.CookieJar
+	http     *http.Client
+	tokens   *models.TokenResponse
+	clientIP string
+}
+
+func (e *Env) NewClient(t *testing.T) *Client {
+	t.Helper()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("create cookie jar: %v", err)
+	}
+
+	return &Client{
+		env:      e,
+		jar:      jar,
+		http:     &http.Client{Jar: jar, Transport: e.transport, Timeout: 5 * time.Second},
+		clientIP: defaultClientIP,
+	}
+}
+
+// NewBearerClient returns a client that keeps no cookies and authenticates
+// with the Authorization header.
+func (e *Env) NewBearerClient(t *testing.T) *Client {
+	t.Helper()
+
+	return &Client{
+		env:      e,
+		http:     &http.Client{Transport: e.transport, Timeout: 5 * time.Second},
+		tokens:   &models.TokenResponse{},
+		clientIP: defaultClientIP,
+	}
+}
+
+// Tokens returns the tokens of a bearer client, SetTokens lets another
+// bearer client reuse them.
+func (c *Client) Tokens() models.TokenResponse {
+	return *c.tokens
+}
+
+func (c *Client) SetTokens(tokens models.TokenResponse) {
+	*c.tokens = tokens
+}
+
+func (c *Client) Register(t *testing.T, login string, password string) int {
+	t.Helper()
+
+	return c.authorize(t, "/api/user/register", login, password)
+}
+
+func (c *Client) Login(t *testing.T, login string, password string) int {
+	t.Helper()
+
+	return c.authorize(t, "/api/user/login", login, password)
+}
+
+func (c *Client) RefreshToken(t *testing.T) int {
+	t.Helper()
+
+	if c.tokens == nil {
+		return closeAndStatus(t, c.do(t, http.MethodPost, "/api/user/token/refresh", "", nil))
+	}
+
+	request := models.RefreshTokenRequest{RefreshToken: c.tokens.RefreshToken}
+
+	return c.readTokens(t, c.do(t, http.MethodPost, "/api/user/token/refresh", "application/json", jsonBody(t, request)))
+}
+
+func (c *Client) Logout(t *testing.T) int {
+	t.Helper()
+
+	return closeAndStatus(t, c.do(t, http.MethodPost, "/api/user/logout", "", nil))
+}
+
+func (c *Client) ChangePassword(t *testing.T, currentPassword string, newPassword string) int {
+	t.Helper()
+
+	request := models.ChangePasswordRequest{CurrentPassword: currentPassword, NewPassword: newPassword}
+
+	return closeAndStatus(t, c.do(t, http.MethodPut, "/api/user/password", "application/json", jsonBody(t, request)))
+}
+
+func (c *Client) RequestPasswordReset(t *testing.T, login string) int {
+	t.Helper()
+
+	request := models.PasswordResetRequest{Login: login}
+
+	return closeAndStatus(t, c.do(t, http.MethodPost, "/api/user/password/reset/request", "application/json", jsonBody(t, request)))
+}
+
+func (c *Client) ResetPassword(t *testing.T, token string, newPassword string) int {
+	t.Helper()
+
+	request := models.ResetPasswordRequest{Token: token, NewPassword: newPassword}
+
+	return closeAndStatus(t, c.do(t, http.MethodPost, "/api/user/password/reset", "application/json", jsonBody(t, request)))
+}
+
+func (c *Client) SetRole(t *testing.T, login string, role string) int {
+	t.Helper()
+
+	request := models.SetRoleRequest{Role: role}
+
+	return closeAndStatus(t, c.do(t, http.MethodPut, "/api/admin/users/"+url.PathEscape(login)+"/role", "application/json", jsonBody(t, request)))
+}
+
+func (c *Client) UnlockLogin(t *testing.T, login string) int {
+	t.Helper()
+
+	return closeAndStatus(t, c.do(t, http.MethodDelete, "/api/admin/users/"+url.PathEscape(login)+"/lockout", "", nil))
+}
+
+// Cookies returns the access and refresh tokens of the client, SetCookies
+// lets another client reuse them.
+func (c *Client) Cookies(t *testing.T) []*http.Cookie {
+	t.Helper()
+
+	return c.jar.Cookies(c.userURL(t))
+}
+
+func (c *Client) SetCookies(t *testing.T, cookies []*http.Cookie) {
+	t.Helper()
+
+	c.jar.SetCookies(c.userURL(t), cookies)
+}
+
+func (c *Client) userURL(t *testing.T) *url.URL {
+	t.Helper()
+
+	u, err := url.Parse(c.env.URL + "/api/user/")
+	if err != nil {
+		t.Fatalf("parse url: %v", err)
+	}
+
+	return u
+}
+
+func (c *Client) UploadOrder(t *testing.T, number string) int {
+	t.Helper()
+
+	return closeAndStatus(t, c.do(t, http.MethodPost, "/api/user/orders", "text/plain", []byte(number)))
+}
+
+func (c *Client) Orders(t *testing.T) (int, models.GetOrdersReponse) {
+	t.Helper()
+
+	var orders models.GetOrdersReponse
+	status := c.get(t, "/api/user/orders", &orders)
+
+	return status, orders
+}
+
+func (c *Client) Balance(t *testing.T) (int, models.GetBalanceResponse) {
+	t.Helper()
+
+	var balance models.GetBalanceResponse
+	status := c.get(t, "/api/user/balance", &balance)
+
+	return status, balance
+}
+
+func (c *Client) Withdraw(t *testing.T, number string, sum money.Amount) int {
+	t.Helper()
+
+	request := models.BalanceWithdrawRequest{OrderNumber: number, Withdrawn: sum}
+
+	return closeAndStatus(t, c.do(t, http.MethodPost, "/api/user/balance/withdraw", "application/json", jsonBody(t, request)))
+}
+
+func (c *Client) Withdrawals(t *testing.T) (int, models.GetWithdrawalsResponse) {
+	t.Helper()
+
+	var withdrawals models.GetWithdrawalsResponse
+	status := c.get(t, "/api/user/withdrawals", &withdrawals)
+
+	return status, withdrawals
+}
+
+// WaitOrderStatus polls the order list until the order has the status.
+func (c *Client) WaitOrderStatus(t *testing.T, number string, status string) models.OrderResponse {
+	t.Helper()
+
+	deadline := time.Now().Add(processingTimeout)
+
+	for {
+		_, orders := c.Orders(t)
+		for _, order := range orders {
+			if order.Number == number && order.Status == status {
+				return order
+			}
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("order %s did not reach status %s in %v, orders: %+v", number, status, processingTimeout, orders)
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func (c *Client) authorize(t *testing.T, path string, login string, password string) int {
+	t.Helper()
+
+	request := models.AuthorizationRequst{Login: login, Password: password}
+
+	response := c.do(t, http.MethodPost, path, "application/json", jsonBody(t, request))
+
+	if c.tokens == nil {
+		return closeAndStatus(t, response)
+	}
+
+	return c.readTokens(t, response)
+}
+
+func (c *Client) readTokens(t *testing.T, response *http.Response) int {
+	t.Helper()
+
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(response.Body).Decode(c.tokens); err != nil {
+			t.Fatalf("decode token response: %v", err)
+		}
+	}
+
+	return response.StatusCode
+}
+
+// do sends the request with the client's cookies or, for bearer clients,
+// asks for JSON and sends the access token in the Authorization header. The
+// client IP is sent the way a reverse proxy appends it, after an address the
+// client made up.
+func (c *Client) do(t *testing.T, method string, path string, contentType string, body []byte) *http.Response {
+	t.Helper()
+
+	request := newRequest(t, method, c.env.URL+path, contentType, body)
+	request.Header.Set("X-Forwarded-For", "203.0.113.1, "+c.clientIP)
+
+	if c.tokens != nil {
+		request.Header.Set("Accept", "application/json")
+
+		if c.tokens.AccessToken != "" {
+			request.Header.Set("Authorization", "Bearer "+c.tokens.AccessToken)
+		}
+	}
+
+	response, err := c.http.Do(request)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+
+	return response
+}
+
+func (c *Client) get(t *testing.T, path string, v any) int {
+	t.Helper()
+
+	response := c.do(t, http.MethodGet, path, "", nil)
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(response.Body).Decode(v); err != nil {
+			t.Fatalf("decode %s response: %v", path, err)
+		}
+	}
+
+	return response.StatusCode
+}
+
+func (e *Env) waitReady(t *testing.T) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		response, err := e.client().Get(e.URL + "/api/health")
+		if err == nil {
+			response.Body.Close()
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("server is not ready: %v", err)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (e *Env) client() *http.Client {
+	return &http.Client{Transport: e.transport, Timeout: 5 * time.Second}
+}
+
+func freeAddress(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("find free port: %v", err)
+	}
+
+	defer listener.Close()
+
+	return listener.Addr().String()
+}
+
+func newRequest(t *testing.T, method string, url string, contentType string, body []byte) *http.Request {
+	t.Helper()
+
+	request, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("create request: %v", err)
+	}
+
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
+	}
+
+	return request
+}
+
+func do(t *testing.T, client *http.Client, method string, url string, contentType string, body []byte) *http.Response {
+	t.Helper()
+
+	response, err := client.Do(newRequest(t, method, url, contentType, body))
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+
+	return response
+}
+
+func closeAndStatus(t *testing.T, response *http.Response) int {
+	t.Helper()
+
+	io.Copy(io.Discard, response.Body)
+	response.Body.Close()
+
+	return response.StatusCode
+}
+
+func expectStatus(t *testing.T, response *http.Response, want int) {
+	t.Helper()
+
+	if got := closeAndStatus(t, response); got != want {
+		t.Fatalf("%s %s: got status %d, want %d", response.Request.Method, response.Request.URL, got, want)
+	}
+}
+
+func jsonBody(t *testing.T, v any) []byte {
+	t.Helper()
+
+	body, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("encode request: %v", err)
+	}
+
+	return body
+}
+
+func assertStatus(t *testing.T, what string, got int, want int) {
+	t.Helper()
+
+	if got != want {
+		t.Fatalf("%s: got status %d, want %d", what, got, want)
+	}
+}
+
+func login(i int) string {
+	return fmt.Sprintf("user%d", i)
+}
@@ -0,0 +1,351 @@
+package server_test
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/VladKvetkin/gophermart/internal/accrualsim"
+	"github.com/VladKvetkin/gophermart/internal/entities"
+	"github.com/VladKvetkin/gophermart/internal/models"
+	"github.com/VladKvetkin/gophermart/internal/money"
+)
+
+func testAuth(t *testing.T, env *Env) {
+	client := env.NewClient(t)
+
+	assertStatus(t, "orders before login", ordersStatus(t, client), http.StatusUnauthorized)
+	assertStatus(t, "register", client.Register(t, login(1), "password"), http.StatusOK)
+	assertStatus(t, "register taken login", env.NewClient(t).Register(t, login(1), "other"), http.StatusConflict)
+	assertStatus(t, "register without password", env.NewClient(t).Register(t, login(2), ""), http.StatusBadRequest)
+	assertStatus(t, "login with wrong password", env.NewClient(t).Login(t, login(1), "wrong"), http.StatusUnauthorized)
+	assertStatus(t, "login of unknown user", env.NewClient(t).Login(t, login(3), "password"), http.StatusUnauthorized)
+
+	other := env.NewClient(t)
+	assertStatus(t, "login", other.Login(t, login(1), "password"), http.StatusOK)
+	assertStatus(t, "orders after login", ordersStatus(t, other), http.StatusNoContent)
+}
+
+func testSessions(t *testing.T, env *Env) {
+	client := env.NewClient(t)
+	assertStatus(t, "register", client.Register(t, login(1), "password"), http.StatusOK)
+
+	stale := env.NewClient(t)
+	stale.SetCookies(t, client.Cookies(t))
+
+	assertStatus(t, "refresh", client.RefreshToken(t), http.StatusOK)
+	assertStatus(t, "orders after refresh", ordersStatus(t, client), http.StatusNoContent)
+	assertStatus(t, "refresh with a rotated refresh token", stale.RefreshToken(t), http.StatusUnauthorized)
+	assertStatus(t, "orders with the access token issued before refresh", ordersStatus(t, stale), http.StatusNoContent)
+
+	other := env.NewClient(t)
+	assertStatus(t, "login in another session", other.Login(t, login(1), "password"), http.StatusOK)
+
+	assertStatus(t, "logout", client.Logout(t), http.StatusOK)
+	assertStatus(t, "orders after logout", ordersStatus(t, client), http.StatusUnauthorized)
+	assertStatus(t, "orders with an access token of the logged out session", ordersStatus(t, stale), http.StatusUnauthorized)
+	assertStatus(t, "refresh after logout", client.RefreshToken(t), http.StatusUnauthorized)
+	assertStatus(t, "orders in another session", ordersStatus(t, other), http.StatusNoContent)
+	assertStatus(t, "refresh without a refresh token", env.NewClient(t).RefreshToken(t), http.StatusUnauthorized)
+	assertStatus(t, "logout without a session", env.NewClient(t).Logout(t), http.StatusUnauthorized)
+}
+
+func testBearerTokens(t *testing.T, env *Env) {
+	client := env.NewBearerClient(t)
+	assertStatus(t, "register", client.Register(t, login(1), "password"), http.StatusOK)
+
+	tokens := client.Tokens()
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" || tokens.TokenType != "Bearer" || tokens.ExpiresIn <= 0 {
+		t.Fatalf("register tokens: got %+v, want bearer access and refresh tokens", tokens)
+	}
+
+	assertStatus(t, "orders with a bearer token", ordersStatus(t, client), http.StatusNoContent)
+
+	stale := env.NewBearerClient(t)
+	stale.SetTokens(tokens)
+
+	assertStatus(t, "refresh with a JSON body", client.RefreshToken(t), http.StatusOK)
+	assertStatus(t, "orders after refresh", ordersStatus(t, client), http.StatusNoContent)
+	assertStatus(t, "refresh with a rotated refresh token", stale.RefreshToken(t), http.StatusUnauthorized)
+
+	for name, header := range map[string]string{
+		"orders with a malformed bearer token": "Bearer not-a-token",
+		"orders with an empty bearer token":    "Bearer ",
+		"orders with another scheme":           "Basic " + client.Tokens().AccessToken,
+	} {
+		assertStatus(t, name, headerStatus(t, env, header), http.StatusUnauthorized)
+	}
+
+	cookie := env.NewClient(t)
+	assertStatus(t, "login with cookies", cookie.Login(t, login(1), "password"), http.StatusOK)
+	assertStatus(t, "orders with a cookie", ordersStatus(t, cookie), http.StatusNoContent)
+
+	assertStatus(t, "logout with a bearer token", client.Logout(t), http.StatusOK)
+	assertStatus(t, "orders after logout", ordersStatus(t, client), http.StatusUnauthorized)
+	assertStatus(t, "orders in the cookie session", ordersStatus(t, cookie), http.StatusNoContent)
+}
+
+func testOrders(t *testing.T, env *Env) {
+	env.AddRewardRule(t, accrualsim.RewardRule{Match: "Bork", Reward: money.FromCents(1000), RewardType: accrualsim.RewardTypePercent})
+	env.RegisterAccrualOrder(t, "12345678903", accrualsim.Good{Description: "Чайник Bork", Price: money.FromCents(700000)})
+
+	client := env.NewClient(t)
+	assertStatus(t, "register", client.Register(t, login(1), "password"), http.StatusOK)
+
+	status, _ := client.Orders(t)
+	assertStatus(t, "orders without uploads", status, http.StatusNoContent)
+
+	assertStatus(t, "upload order failing luhn", client.UploadOrder(t, "12345678902"), http.StatusUnprocessableEntity)
+	assertStatus(t, "upload order", client.UploadOrder(t, "12345678903"), http.StatusAccepted)
+	assertStatus(t, "upload same order again", client.UploadOrder(t, "12345678903"), http.StatusOK)
+
+	other := env.NewClient(t)
+	assertStatus(t, "register other user", other.Register(t, login(2), "password"), http.StatusOK)
+	assertStatus(t, "upload order of other user", other.UploadOrder(t, "12345678903"), http.StatusConflict)
+
+	order := client.WaitOrderStatus(t, "12345678903", entities.OrderStatusProcessed)
+	if order.Accrual != money.FromCents(70000) {
+		t.Fatalf("processed order accrual: got %v, want 700", order.Accrual)
+	}
+
+	status, orders := client.Orders(t)
+	assertStatus(t, "orders", status, http.StatusOK)
+
+	if len(orders) != 1 || orders[0].UploadedAt == "" {
+		t.Fatalf("orders: got %+v, want the single uploaded order", orders)
+	}
+}
+
+func testBalance(t *testing.T, env *Env) {
+	env.AddRewardRule(t, accrualsim.RewardRule{Match: "Bork", Reward: money.FromCents(50000), RewardType: accrualsim.RewardTypePoints})
+	env.RegisterAccrualOrder(t, "12345678903", accrualsim.Good{Description: "Чайник Bork", Price: money.FromCents(700000)})
+
+	client := env.NewClient(t)
+	assertStatus(t, "register", client.Register(t, login(1), "password"), http.StatusOK)
+
+	status, withdrawals := client.Withdrawals(t)
+	assertStatus(t, "withdrawals without withdraws", status, http.StatusNoContent)
+
+	assertStatus(t, "withdraw from empty balance", client.Withdraw(t, "2377225624", money.FromCents(1)), http.StatusPaymentRequired)
+
+	assertStatus(t, "upload order", client.UploadOrder(t, "12345678903"), http.StatusAccepted)
+	client.WaitOrderStatus(t, "12345678903", entities.OrderStatusProcessed)
+
+	status, balance := client.Balance(t)
+	assertStatus(t, "balance", status, http.StatusOK)
+
+	if balance.Accrual != money.FromCents(50000) || balance.Withdranw != 0 {
+		t.Fatalf("balance after accrual: got %+v, want 500 current and 0 withdrawn", balance)
+	}
+
+	assertStatus(t, "withdraw for order failing luhn", client.Withdraw(t, "2377225625", money.FromCents(10000)), http.StatusUnprocessableEntity)
+	assertStatus(t, "withdraw more than balance", client.Withdraw(t, "2377225624", money.FromCents(50001)), http.StatusPaymentRequired)
+	assertStatus(t, "withdraw", client.Withdraw(t, "2377225624", money.FromCents(12050)), http.StatusOK)
+
+	status, balance = client.Balance(t)
+	assertStatus(t, "balance", status, http.StatusOK)
+
+	if balance.Accrual != money.FromCents(37950) || balance.Withdranw != money.FromCents(12050) {
+		t.Fatalf("balance after withdraw: got %+v, want 379.5 current and 120.5 withdrawn", balance)
+	}
+
+	status, withdrawals = client.Withdrawals(t)
+	assertStatus(t, "withdrawals", status, http.StatusOK)
+
+	if len(withdrawals) != 1 || withdrawals[0].Number != "2377225624" || withdrawals[0].Withdrawn != money.FromCents(12050) {
+		t.Fatalf("withdrawals: got %+v, want a single withdrawal of 120.5 for 2377225624", withdrawals)
+	}
+
+	other := env.NewClient(t)
+	assertStatus(t, "register other user", other.Register(t, login(2), "password"), http.StatusOK)
+
+	status, _ = other.Withdrawals(t)
+	assertStatus(t, "withdrawals of other user", status, http.StatusNoContent)
+}
+
+func testInvalidOrder(t *testing.T, env *Env) {
+	env.RegisterAccrualOrder(t, "9278923470", accrualsim.Good{Description: "Spoon", Price: money.FromCents(10000)})
+
+	client := env.NewClient(t)
+	assertStatus(t, "register", client.Register(t, login(1), "password"), http.StatusOK)
+	assertStatus(t, "upload order", client.UploadOrder(t, "9278923470"), http.StatusAccepted)
+
+	order := client.WaitOrderStatus(t, "9278923470", entities.OrderStatusInvalid)
+	if order.Accrual != 0 {
+		t.Fatalf("invalid order accrual: got %v, want 0", order.Accrual)
+	}
+
+	status, balance := client.Balance(t)
+	assertStatus(t, "balance", status, http.StatusOK)
+
+	if balance.Accrual != 0 {
+		t.Fatalf("balance after invalid order: got %+v, want 0 current", balance)
+	}
+}
+
+func testLoginLockout(t *testing.T, env *Env) {
+	client := env.NewClient(t)
+	assertStatus(t, "register", client.Register(t, login(1), "password"), http.StatusOK)
+	assertStatus(t, "register", env.NewClient(t).Register(t, login(2), "password"), http.StatusOK)
+
+	for i := 0; i < 3; i++ {
+		assertStatus(t, "login with wrong password", env.NewClient(t).Login(t, login(1), "wrong"), http.StatusUnauthorized)
+	}
+
+	status, retryAfter := loginRetryAfter(t, env, login(1), "password")
+	assertStatus(t, "login of a locked out login", status, http.StatusTooManyRequests)
+
+	if seconds, err := strconv.Atoi(retryAfter); err != nil || seconds <= 0 || seconds > 60 {
+		t.Fatalf("login of a locked out login: got Retry-After %q, want 1 to 60 seconds", retryAfter)
+	}
+
+	assertStatus(t, "login of another login", env.NewClient(t).Login(t, login(2), "password"), http.StatusOK)
+
+	// Failures of the client IP are kept after a successful login, the
+	// remaining ones lock out every login from it.
+	for i := 0; i < 7; i++ {
+		assertStatus(t, "login of unknown user", env.NewClient(t).Login(t, login(10+i), "password"), http.StatusUnauthorized)
+	}
+
+	status, retryAfter = loginRetryAfter(t, env, login(2), "password")
+	assertStatus(t, "login from a locked out IP", status, http.StatusTooManyRequests)
+
+	if retryAfter == "" {
+		t.Fatal("login from a locked out IP: got no Retry-After")
+	}
+
+	other := env.NewClient(t)
+	other.clientIP = "192.0.2.2"
+	assertStatus(t, "login from another IP", other.Login(t, login(2), "password"), http.StatusOK)
+
+	assertStatus(t, "orders of a session started before the lockout", ordersStatus(t, client), http.StatusNoContent)
+}
+
+func testChangePassword(t *testing.T, env *Env) {
+	client := env.NewClient(t)
+	assertStatus(t, "register", client.Register(t, login(1), "password"), http.StatusOK)
+
+	other := env.NewClient(t)
+	assertStatus(t, "login in another session", other.Login(t, login(1), "password"), http.StatusOK)
+
+	assertStatus(t, "change password before login", env.NewClient(t).ChangePassword(t, "password", "new"), http.StatusUnauthorized)
+	assertStatus(t, "change password without new password", client.ChangePassword(t, "password", ""), http.StatusBadRequest)
+	assertStatus(t, "change password with wrong current password", client.ChangePassword(t, "wrong", "new"), http.StatusForbidden)
+	assertStatus(t, "change password", client.ChangePassword(t, "password", "new"), http.StatusOK)
+
+	assertStatus(t, "orders in the changing session", ordersStatus(t, client), http.StatusNoContent)
+	assertStatus(t, "orders in another session", ordersStatus(t, other), http.StatusUnauthorized)
+	assertStatus(t, "refresh in another session", other.RefreshToken(t), http.StatusUnauthorized)
+	assertStatus(t, "login with old password", env.NewClient(t).Login(t, login(1), "password"), http.StatusUnauthorized)
+	assertStatus(t, "login with new password", env.NewClient(t).Login(t, login(1), "new"), http.StatusOK)
+}
+
+func testPasswordReset(t *testing.T, env *Env) {
+	client := env.NewClient(t)
+	assertStatus(t, "register", client.Register(t, login(1), "password"), http.StatusOK)
+
+	assertStatus(t, "reset request of unknown login", env.NewClient(t).RequestPasswordReset(t, login(2)), http.StatusAccepted)
+	assertStatus(t, "reset request", env.NewClient(t).RequestPasswordReset(t, login(1)), http.StatusAccepted)
+	stale := env.Mailbox.PasswordResetToken(t, login(1))
+
+	assertStatus(t, "reset request", env.NewClient(t).RequestPasswordReset(t, login(1)), http.StatusAccepted)
+	token := env.Mailbox.PasswordResetToken(t, login(1))
+
+	assertStatus(t, "reset with unknown token", env.NewClient(t).ResetPassword(t, "unknown", "new"), http.StatusUnauthorized)
+	assertStatus(t, "reset without new password", env.NewClient(t).ResetPassword(t, token, ""), http.StatusBadRequest)
+	assertStatus(t, "reset", env.NewClient(t).ResetPassword(t, token, "new"), http.StatusOK)
+	assertStatus(t, "reset with a used token", env.NewClient(t).ResetPassword(t, token, "other"), http.StatusUnauthorized)
+	assertStatus(t, "reset with a token issued before the reset", env.NewClient(t).ResetPassword(t, stale, "other"), http.StatusUnauthorized)
+
+	assertStatus(t, "orders in a session started before the reset", ordersStatus(t, client), http.StatusUnauthorized)
+	assertStatus(t, "login with old password", env.NewClient(t).Login(t, login(1), "password"), http.StatusUnauthorized)
+	assertStatus(t, "login with new password", env.NewClient(t).Login(t, login(1), "new"), http.StatusOK)
+
+	// Requests are limited per login whether it exists or not.
+	assertStatus(t, "reset request", env.NewClient(t).RequestPasswordReset(t, login(1)), http.StatusAccepted)
+	env.Mailbox.PasswordResetToken(t, login(1))
+	assertStatus(t, "reset request over the limit", env.NewClient(t).RequestPasswordReset(t, login(1)), http.StatusTooManyRequests)
+
+	for i := 0; i < 2; i++ {
+		assertStatus(t, "reset request of unknown login", env.NewClient(t).RequestPasswordReset(t, login(2)), http.StatusAccepted)
+	}
+
+	assertStatus(t, "reset request of unknown login over the limit", env.NewClient(t).RequestPasswordReset(t, login(2)), http.StatusTooManyRequests)
+
+	if sent := env.Mailbox.PasswordResetTokens(login(2)); sent != 0 {
+		t.Fatalf("reset tokens sent to unknown login: got %d, want 0", sent)
+	}
+
+	if sent := env.Mailbox.PasswordResetTokens(login(1)); sent != 3 {
+		t.Fatalf("reset tokens sent: got %d, want 3", sent)
+	}
+}
+
+func testAdmin(t *testing.T, env *Env) {
+	admin := env.NewClient(t)
+	assertStatus(t, "register", admin.Register(t, login(1), "password"), http.StatusOK)
+
+	user := env.NewBearerClient(t)
+	assertStatus(t, "register", user.Register(t, login(2), "password"), http.StatusOK)
+
+	assertStatus(t, "admin endpoint before login", env.NewClient(t).SetRole(t, login(2), entities.RoleAdmin), http.StatusUnauthorized)
+	assertStatus(t, "admin endpoint as user", admin.SetRole(t, login(2), entities.RoleAdmin), http.StatusForbidden)
+
+	env.GrantRole(t, login(1), entities.RoleAdmin)
+
+	assertStatus(t, "admin endpoint in a session started before the grant", admin.SetRole(t, login(2), entities.RoleAdmin), http.StatusUnauthorized)
+	assertStatus(t, "login as admin", admin.Login(t, login(1), "password"), http.StatusOK)
+	assertStatus(t, "orders as admin", ordersStatus(t, admin), http.StatusNoContent)
+
+	assertStatus(t, "set unknown role", admin.SetRole(t, login(2), "root"), http.StatusBadRequest)
+	assertStatus(t, "set role of unknown login", admin.SetRole(t, login(3), entities.RoleAdmin), http.StatusNotFound)
+	assertStatus(t, "grant admin", admin.SetRole(t, login(2), entities.RoleAdmin), http.StatusOK)
+	assertStatus(t, "orders in a session started before the grant", ordersStatus(t, user), http.StatusUnauthorized)
+	assertStatus(t, "login as new admin", user.Login(t, login(2), "password"), http.StatusOK)
+	assertStatus(t, "admin endpoint as new admin", user.SetRole(t, login(2), entities.RoleAdmin), http.StatusOK)
+	assertStatus(t, "revoke admin", admin.SetRole(t, login(2), entities.RoleUser), http.StatusOK)
+	assertStatus(t, "refresh after revoke", user.RefreshToken(t), http.StatusUnauthorized)
+	assertStatus(t, "login after revoke", user.Login(t, login(2), "password"), http.StatusOK)
+	assertStatus(t, "admin endpoint after revoke", user.UnlockLogin(t, login(2)), http.StatusForbidden)
+
+	for i := 0; i < 3; i++ {
+		assertStatus(t, "login with wrong password", env.NewClient(t).Login(t, login(2), "wrong"), http.StatusUnauthorized)
+	}
+
+	assertStatus(t, "login of a locked out login", env.NewClient(t).Login(t, login(2), "password"), http.StatusTooManyRequests)
+	assertStatus(t, "unlock login", admin.UnlockLogin(t, login(2)), http.StatusOK)
+	assertStatus(t, "login after unlock", env.NewClient(t).Login(t, login(2), "password"), http.StatusOK)
+}
+
+func ordersStatus(t *testing.T, client *Client) int {
+	t.Helper()
+
+	status, _ := client.Orders(t)
+	return status
+}
+
+// headerStatus requests the orders with a raw Authorization header.
+func headerStatus(t *testing.T, env *Env, authorization string) int {
+	t.Helper()
+
+	request := newRequest(t, http.MethodGet, env.URL+"/api/user/orders", "", nil)
+	request.Header.Set("Authorization", authorization)
+
+	response, err := env.client().Do(request)
+	if err != nil {
+		t.Fatalf("GET /api/user/orders: %v", err)
+	}
+
+	return closeAndStatus(t, response)
+}
+
+// loginRetryAfter logs in and returns the status with the Retry-After header.
+func loginRetryAfter(t *testing.T, env *Env, login string, password string) (int, string) {
+	t.Helper()
+
+	request := models.AuthorizationRequst{Login: login, Password: password}
+
+	response := env.NewClient(t).do(t, http.MethodPost, "/api/user/login", "application/json", jsonBody(t, request))
+
+	return closeAndStatus(t, response), response.Header.Get("Retry-After")
+}
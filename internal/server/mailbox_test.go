@@ -0,0 +1,74 @@
+package server_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Mailbox is the notifier of the server under test, it keeps every
+// password reset token sent to every login.
+type Mailbox struct {
+	mu          sync.Mutex
+	resetTokens map[string][]string
+	read        map[string]int
+}
+
+func NewMailbox() *Mailbox {
+	return &Mailbox{
+		resetTokens: make(map[string][]string),
+		read:        make(map[string]int),
+	}
+}
+
+func (m *Mailbox) SendPasswordReset(ctx context.Context, login string, token string, expiresIn time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.resetTokens[login] = append(m.resetTokens[login], token)
+
+	return nil
+}
+
+// PasswordResetToken returns the next password reset token sent to login,
+// waiting for it as tokens are delivered after the request is answered.
+func (m *Mailbox) PasswordResetToken(t *testing.T, login string) string {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		if token, ok := m.next(login); ok {
+			return token
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("no password reset token was sent to %s", login)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// PasswordResetTokens returns how many password reset tokens were sent to
+// login so far.
+func (m *Mailbox) PasswordResetTokens(login string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.resetTokens[login])
+}
+
+func (m *Mailbox) next(login string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.read[login] == len(m.resetTokens[login]) {
+		return "", false
+	}
+
+	m.read[login]++
+
+	return m.resetTokens[login][m.read[login]-1], true
+}
@@ -4,6 +4,7 @@ import (
 	"compress/gzip"
 	"net/http"
 
+	"github.com/VladKvetkin/gophermart/internal/entities"
 	"github.com/VladKvetkin/gophermart/internal/handler"
 	"github.com/VladKvetkin/gophermart/internal/middleware"
 	"github.com/go-chi/chi"
@@ -15,12 +16,20 @@ func (s *Server) setupRoutes(handler *handler.Handler) {
 
 	s.mux.Route("/", func(r chi.Router) {
 		r.Route("/api", func(r chi.Router) {
+			r.Get("/health", http.HandlerFunc(handler.Health))
+
 			r.Route("/user", func(r chi.Router) {
 				r.Post("/login", http.HandlerFunc(handler.Login))
 				r.Post("/register", http.HandlerFunc(handler.Register))
+				r.Post("/token/refresh", http.HandlerFunc(handler.RefreshToken))
+				r.Post("/password/reset/request", http.HandlerFunc(handler.RequestPasswordReset))
+				r.Post("/password/reset", http.HandlerFunc(handler.ResetPassword))
 
 				r.Group(func(r chi.Router) {
-					r.Use(middleware.Auth)
+					r.Use(middleware.Auth(s.tokens, s.storage))
+
+					r.Post("/logout", http.HandlerFunc(handler.Logout))
+					r.Put("/password", http.HandlerFunc(handler.ChangePassword))
 
 					r.Post("/orders", http.HandlerFunc(handler.SaveOrder))
 					r.Get("/orders", http.HandlerFunc(handler.GetOrders))
@@ -33,7 +42,18 @@ func (s *Server) setupRoutes(handler *handler.Handler) {
 					r.Get("/withdrawals", http.HandlerFunc(handler.GetWithdrawals))
 				})
 			})
+
+			r.Route("/admin", func(r chi.Router) {
+				r.Use(middleware.Auth(s.tokens, s.storage), middleware.RequireRole(entities.RoleAdmin))
+
+				r.Put("/users/{login}/role", http.HandlerFunc(handler.SetUserRole))
+				r.Delete("/users/{login}/lockout", http.HandlerFunc(handler.UnlockLogin))
+			})
 		})
+
+		if s.config.AccrualCallbackSecret != "" {
+			r.Post("/internal/accrual/callback", http.HandlerFunc(handler.AccrualCallback))
+		}
 	})
 }
 
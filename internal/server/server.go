@@ -8,25 +8,35 @@ import (
 
 	"github.com/VladKvetkin/gophermart/internal/config"
 	"github.com/VladKvetkin/gophermart/internal/handler"
+	"github.com/VladKvetkin/gophermart/internal/services/jwttoken"
+	"github.com/VladKvetkin/gophermart/internal/services/notifier"
 	"github.com/VladKvetkin/gophermart/internal/storage"
 	"github.com/go-chi/chi"
 	"go.uber.org/zap"
 )
 
 type Server struct {
-	config  config.Config
-	mux     chi.Router
-	server  *http.Server
-	storage storage.Storage
+	config        config.Config
+	mux           chi.Router
+	server        *http.Server
+	storage       storage.Storage
+	tokens        *jwttoken.Manager
+	accrualHealth handler.AccrualHealth
+	accrualQueue  handler.AccrualQueue
+	notifier      notifier.Notifier
 }
 
-func NewServer(config config.Config, storage storage.Storage) *Server {
+func NewServer(config config.Config, storage storage.Storage, tokens *jwttoken.Manager, accrualHealth handler.AccrualHealth, accrualQueue handler.AccrualQueue, notifier notifier.Notifier) *Server {
 	mux := chi.NewMux()
 
 	return &Server{
-		config:  config,
-		mux:     mux,
-		storage: storage,
+		config:        config,
+		mux:           mux,
+		storage:       storage,
+		tokens:        tokens,
+		accrualHealth: accrualHealth,
+		accrualQueue:  accrualQueue,
+		notifier:      notifier,
 		server: &http.Server{
 			Addr:              config.Address,
 			Handler:           mux,
@@ -39,7 +49,7 @@ func NewServer(config config.Config, storage storage.Storage) *Server {
 }
 
 func (s *Server) Start() error {
-	s.setupRoutes(handler.NewHandler(s.storage))
+	s.setupRoutes(handler.NewHandler(s.config, s.storage, s.tokens, s.accrualHealth, s.accrualQueue, s.notifier))
 
 	zap.L().Info("starting server", zap.String("address", s.config.Address))
 
@@ -1,60 +1,235 @@
 package jwttoken
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"os"
+	"strings"
 	"time"
 
+	"github.com/VladKvetkin/gophermart/internal/config"
+	"github.com/VladKvetkin/gophermart/internal/entities"
 	"github.com/golang-jwt/jwt/v4"
+	"go.uber.org/zap"
 )
 
-const (
-	salt      = "nvbnergtefgsdfg314"
-	secretKey = "asfdvcbiuyyrn#5241"
-	tokenExp  = time.Hour * 3
-)
+// HMAC-SHA256 keys shorter than the hash give no security margin.
+const minSecretLen = 32
+
+var ErrInvalidToken = errors.New("token is not valid")
+
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Subject is who a token is issued to: the user with their role and the
+// session it belongs to, so revoking the session revokes its tokens.
+type Subject struct {
+	UserID    string
+	SessionID string
+	Role      string
+}
 
 type claims struct {
 	jwt.RegisteredClaims
-	UserID string
+	UserID    string
+	SessionID string
+	Role      string `json:",omitempty"`
+}
+
+// Manager signs tokens with the first configured key and accepts tokens of
+// any configured key. A key is rotated by putting a new one first and
+// keeping the old one until the tokens it signed have expired.
+type Manager struct {
+	signingKey Key
+	keys       map[string][]byte
+	expiry     time.Duration
+	issuer     string
+	audience   string
+}
+
+func NewManager(config config.Config) (*Manager, error) {
+	keys, err := loadKeys(config.JWTKeys, config.JWTKeysFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		if !config.JWTAllowRandomKey {
+			return nil, errors.New("no JWT keys configured")
+		}
+
+		zap.L().Info("no JWT keys configured, using a random key: tokens will not survive a restart and are not shared between replicas")
+
+		key, err := randomKey()
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	manager := &Manager{
+		signingKey: keys[0],
+		keys:       make(map[string][]byte, len(keys)),
+		expiry:     config.JWTExpiry,
+		issuer:     config.JWTIssuer,
+		audience:   config.JWTAudience,
+	}
+
+	for _, key := range keys {
+		if len(key.Secret) < minSecretLen {
+			return nil, fmt.Errorf("JWT key %q is shorter than %d bytes", key.ID, minSecretLen)
+		}
+
+		if _, ok := manager.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate JWT key id %q", key.ID)
+		}
+
+		manager.keys[key.ID] = key.Secret
+	}
+
+	return manager, nil
 }
 
-func Parse(accessToken string) (string, error) {
+func (m *Manager) Parse(accessToken string) (Subject, error) {
 	claims := &claims{}
 
 	token, err := jwt.ParseWithClaims(
 		accessToken,
 		claims,
 		func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			if t.Method != jwt.SigningMethodHS256 {
 				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
 			}
-			return []byte(secretKey), nil
+
+			kid, _ := t.Header["kid"].(string)
+
+			secret, ok := m.keys[kid]
+			if !ok {
+				return nil, fmt.Errorf("unknown key id %q", kid)
+			}
+
+			return secret, nil
 		},
 	)
 
 	if err != nil {
-		return "", err
+		return Subject{}, err
 	}
 
-	if !token.Valid || claims.UserID == "" {
-		return "", fmt.Errorf("token is not valid")
+	if !token.Valid || claims.UserID == "" || claims.SessionID == "" {
+		return Subject{}, ErrInvalidToken
 	}
 
-	return claims.UserID, nil
+	if !claims.VerifyIssuer(m.issuer, true) || !claims.VerifyAudience(m.audience, true) {
+		return Subject{}, ErrInvalidToken
+	}
+
+	// Tokens issued before roles existed belong to ordinary users.
+	role := claims.Role
+	if role == "" {
+		role = entities.RoleUser
+	}
+
+	return Subject{UserID: claims.UserID, SessionID: claims.SessionID, Role: role}, nil
 }
 
-func Generate(userID string) (string, error) {
+func (m *Manager) Generate(subject Subject) (string, error) {
+	now := time.Now()
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims{
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(tokenExp)),
+			Issuer:    m.issuer,
+			Audience:  jwt.ClaimStrings{m.audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(m.expiry)),
 		},
-		UserID: userID,
+		UserID:    subject.UserID,
+		SessionID: subject.SessionID,
+		Role:      subject.Role,
 	})
 
-	accessToken, err := token.SignedString([]byte(secretKey))
+	token.Header["kid"] = m.signingKey.ID
+
+	accessToken, err := token.SignedString(m.signingKey.Secret)
 	if err != nil {
 		return "", err
 	}
 
 	return accessToken, nil
 }
+
+// NewRefreshToken returns an opaque refresh token and the hash it is stored
+// under. The token is random enough for a plain SHA-256 to be safe.
+func NewRefreshToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	return token, HashRefreshToken(token), nil
+}
+
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadKeys reads "kid:secret" pairs, comma separated in keys and one per
+// line in the file, lines starting with # are skipped.
+func loadKeys(keys string, file string) ([]Key, error) {
+	var pairs []string
+
+	for _, pair := range strings.Split(keys, ",") {
+		if pair = strings.TrimSpace(pair); pair != "" {
+			pairs = append(pairs, pair)
+		}
+	}
+
+	if file != "" {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read JWT keys file: %w", err)
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				pairs = append(pairs, line)
+			}
+		}
+	}
+
+	result := make([]Key, 0, len(pairs))
+
+	for _, pair := range pairs {
+		id, secret, ok := strings.Cut(pair, ":")
+		if !ok || id == "" {
+			return nil, errors.New(`JWT key must be written as "kid:secret"`)
+		}
+
+		result = append(result, Key{ID: id, Secret: []byte(secret)})
+	}
+
+	return result, nil
+}
+
+func randomKey() (Key, error) {
+	secret := make([]byte, minSecretLen)
+	if _, err := rand.Read(secret); err != nil {
+		return Key{}, err
+	}
+
+	return Key{ID: "random-" + hex.EncodeToString(secret[:4]), Secret: secret}, nil
+}
@@ -0,0 +1,140 @@
+package jwttoken_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VladKvetkin/gophermart/internal/config"
+	"github.com/VladKvetkin/gophermart/internal/entities"
+	"github.com/VladKvetkin/gophermart/internal/services/jwttoken"
+)
+
+var (
+	oldKey = "old:" + strings.Repeat("o", 32)
+	newKey = "new:" + strings.Repeat("n", 32)
+)
+
+func TestManager(t *testing.T) {
+	subject := jwttoken.Subject{UserID: "user", SessionID: "session", Role: entities.RoleUser}
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		manager := newManager(t, tokenConfig(newKey))
+
+		got, err := manager.Parse(generate(t, manager, subject))
+		if err != nil || got != subject {
+			t.Fatalf("Parse: got %+v, %v, want %+v", got, err, subject)
+		}
+	})
+
+	// After a rotation tokens signed with the old key stay valid as long as
+	// the key is still configured.
+	t.Run("Rotation", func(t *testing.T) {
+		before := newManager(t, tokenConfig(oldKey))
+		after := newManager(t, tokenConfig(newKey+","+oldKey))
+
+		got, err := after.Parse(generate(t, before, subject))
+		if err != nil || got != subject {
+			t.Fatalf("Parse of a token signed with the old key: got %+v, %v, want %+v", got, err, subject)
+		}
+
+		if _, err := before.Parse(generate(t, after, subject)); err == nil {
+			t.Fatal("Parse of a token signed with a key the manager does not have: got no error")
+		}
+	})
+
+	// A known secret under an unknown id is still rejected.
+	t.Run("UnknownKeyID", func(t *testing.T) {
+		other := newManager(t, tokenConfig("other:"+strings.Repeat("o", 32)))
+		manager := newManager(t, tokenConfig(oldKey))
+
+		if _, err := manager.Parse(generate(t, other, subject)); err == nil {
+			t.Fatal("Parse of a token with an unknown kid: got no error")
+		}
+	})
+
+	t.Run("WrongIssuer", func(t *testing.T) {
+		config := tokenConfig(newKey)
+		config.JWTIssuer = "someone-else"
+
+		if _, err := newManager(t, tokenConfig(newKey)).Parse(generate(t, newManager(t, config), subject)); err == nil {
+			t.Fatal("Parse of a token with a wrong issuer: got no error")
+		}
+	})
+
+	t.Run("WrongAudience", func(t *testing.T) {
+		config := tokenConfig(newKey)
+		config.JWTAudience = "someone-else"
+
+		if _, err := newManager(t, tokenConfig(newKey)).Parse(generate(t, newManager(t, config), subject)); err == nil {
+			t.Fatal("Parse of a token with a wrong audience: got no error")
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		config := tokenConfig(newKey)
+		config.JWTExpiry = -time.Minute
+
+		if _, err := newManager(t, tokenConfig(newKey)).Parse(generate(t, newManager(t, config), subject)); err == nil {
+			t.Fatal("Parse of an expired token: got no error")
+		}
+	})
+}
+
+func TestNewManager(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  config.Config
+		wantErr bool
+	}{
+		{"NoKeys", tokenConfig(""), true},
+		{"RandomKey", func() config.Config {
+			config := tokenConfig("")
+			config.JWTAllowRandomKey = true
+			return config
+		}(), false},
+		{"ShortKey", tokenConfig("short:secret"), true},
+		{"DuplicateKeyID", tokenConfig(oldKey + "," + oldKey), true},
+		{"NoKeyID", tokenConfig(":" + strings.Repeat("s", 32)), true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := jwttoken.NewManager(tt.config); (err != nil) != tt.wantErr {
+				t.Fatalf("NewManager: got error %v, want error %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func tokenConfig(keys string) config.Config {
+	return config.Config{
+		JWTKeys:     keys,
+		JWTExpiry:   time.Minute,
+		JWTIssuer:   "gophermart",
+		JWTAudience: "gophermart",
+	}
+}
+
+func newManager(t *testing.T, config config.Config) *jwttoken.Manager {
+	t.Helper()
+
+	manager, err := jwttoken.NewManager(config)
+	if err != nil {
+		t.Fatalf("NewManager: %v", err)
+	}
+
+	return manager
+}
+
+func generate(t *testing.T, manager *jwttoken.Manager, subject jwttoken.Subject) string {
+	t.Helper()
+
+	token, err := manager.Generate(subject)
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	return token
+}
@@ -0,0 +1,150 @@
+package loginguard
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/VladKvetkin/gophermart/internal/config"
+	"github.com/VladKvetkin/gophermart/internal/storage"
+)
+
+const (
+	loginKeyPrefix = "login:"
+	ipKeyPrefix    = "ip:"
+
+	// Password reset requests are counted apart from failed logins.
+	resetKeyPrefix = "reset-"
+)
+
+// Guard tracks failed logins per login and per client IP in storage, so
+// lockouts survive restarts and are shared by all replicas.
+type Guard struct {
+	config  config.Config
+	storage storage.Storage
+}
+
+func NewGuard(config config.Config, storage storage.Storage) *Guard {
+	return &Guard{
+		config:  config,
+		storage: storage,
+	}
+}
+
+// ClientIP returns the client IP of req from the header set by the trusted
+// reverse proxy, the last address when the proxy appends to a list like
+// X-Forwarded-For. Without a configured header, or without a valid address
+// in it, it returns an empty string: RemoteAddr may be the proxy itself, and
+// every client would share its lockout.
+func (g *Guard) ClientIP(req *http.Request) string {
+	if g.config.LoginClientIPHeader == "" {
+		return ""
+	}
+
+	values := strings.Split(req.Header.Get(g.config.LoginClientIPHeader), ",")
+
+	ip := net.ParseIP(strings.TrimSpace(values[len(values)-1]))
+	if ip == nil {
+		return ""
+	}
+
+	return ip.String()
+}
+
+// Attempt counts an attempt for login from clientIP before the password
+// is checked and returns the time left until attempts are allowed again,
+// zero if this one is. Every attempt counts as failed until Success, so
+// parallel guesses are limited as well as sequential ones.
+//
+// A login is delayed after every failure, the delay doubles up to the limit
+// of failures, then the login is locked out and the lockout doubles on every
+// further failure. A client IP is only locked out, once its limit is reached,
+// and only counted when it is known.
+func (g *Guard) Attempt(ctx context.Context, login string, clientIP string) (time.Duration, error) {
+	return g.attempt(ctx, loginKey(login), ipKey(clientIP), clientIP != "", g.config.LoginMaxFailures, g.config.LoginFailureDelay)
+}
+
+// PasswordResetRequest counts a password reset request for login from
+// clientIP the way Attempt counts logins, but without a delay: the login is
+// locked out once its limit of requests is reached. Requests are never
+// taken back, they are forgotten after the maximum lockout like failures.
+// Unknown logins are counted as well, so the answer tells nothing about
+// whether a login exists.
+func (g *Guard) PasswordResetRequest(ctx context.Context, login string, clientIP string) (time.Duration, error) {
+	return g.attempt(ctx, resetKeyPrefix+loginKey(login), resetKeyPrefix+ipKey(clientIP), clientIP != "", g.config.PasswordResetMaxRequests, 0)
+}
+
+func (g *Guard) attempt(ctx context.Context, key string, ip string, useIP bool, maxFailures int, delay time.Duration) (time.Duration, error) {
+	if useIP {
+		lockout, err := g.storage.RecordLoginAttempt(ctx, ip, g.config.LoginLockoutMax, func(attempts int) time.Duration {
+			return g.lockout(attempts, g.config.LoginIPMaxFailures, 0)
+		})
+		if err != nil || lockout > 0 {
+			return lockout, err
+		}
+	}
+
+	lockout, err := g.storage.RecordLoginAttempt(ctx, key, g.config.LoginLockoutMax, func(attempts int) time.Duration {
+		return g.lockout(attempts, maxFailures, delay)
+	})
+	if err != nil || lockout == 0 || !useIP {
+		return lockout, err
+	}
+
+	// The attempt is refused, it must not count against the client IP.
+	return lockout, g.storage.ForgetLoginAttempt(ctx, ip)
+}
+
+// Success forgets the failures of login and takes back the attempt of the
+// client IP. Earlier failures of the client IP are kept, so guessing
+// passwords for many logins is still limited.
+func (g *Guard) Success(ctx context.Context, login string, clientIP string) error {
+	if err := g.storage.ResetLoginFailures(ctx, loginKey(login)); err != nil || clientIP == "" {
+		return err
+	}
+
+	return g.storage.ForgetLoginAttempt(ctx, ipKey(clientIP))
+}
+
+// Unlock forgets the failures and the lockout of login.
+func (g *Guard) Unlock(ctx context.Context, login string) error {
+	return g.storage.ResetLoginFailures(ctx, loginKey(login))
+}
+
+// lockout returns the time a key is locked for after its failures: delay
+// doubled on every failure below maxFailures, then the lockout base doubled
+// on every failure beyond it.
+func (g *Guard) lockout(failures int, maxFailures int, delay time.Duration) time.Duration {
+	if failures < maxFailures {
+		for i := 1; i < failures && delay < g.config.LoginLockoutBase; i++ {
+			delay *= 2
+		}
+
+		if delay > g.config.LoginLockoutBase {
+			return g.config.LoginLockoutBase
+		}
+
+		return delay
+	}
+
+	lockout := g.config.LoginLockoutBase
+	for i := 0; i < failures-maxFailures && lockout < g.config.LoginLockoutMax; i++ {
+		lockout *= 2
+	}
+
+	if lockout > g.config.LoginLockoutMax {
+		return g.config.LoginLockoutMax
+	}
+
+	return lockout
+}
+
+func loginKey(login string) string {
+	return loginKeyPrefix + login
+}
+
+func ipKey(clientIP string) string {
+	return ipKeyPrefix + clientIP
+}
@@ -0,0 +1,45 @@
+package loginguard_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/VladKvetkin/gophermart/internal/config"
+	"github.com/VladKvetkin/gophermart/internal/services/loginguard"
+	"github.com/VladKvetkin/gophermart/internal/storage"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		want   string
+	}{
+		{"NoHeaderConfigured", "", "192.0.2.1", ""},
+		{"Missing", "X-Real-IP", "", ""},
+		{"Single", "X-Real-IP", "192.0.2.1", "192.0.2.1"},
+		{"IPv6", "X-Real-IP", "2001:db8::1", "2001:db8::1"},
+		{"AppendedByProxy", "X-Forwarded-For", "203.0.113.1, 192.0.2.1", "192.0.2.1"},
+		{"NotAnAddress", "X-Forwarded-For", "192.0.2.1, unknown", ""},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			guard := loginguard.NewGuard(config.Config{LoginClientIPHeader: tt.header}, storage.NewMemoryStorage())
+
+			req := httptest.NewRequest(http.MethodPost, "/api/user/login", nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			if tt.value != "" {
+				req.Header.Set("X-Real-IP", tt.value)
+				req.Header.Set("X-Forwarded-For", tt.value)
+			}
+
+			if got := guard.ClientIP(req); got != tt.want {
+				t.Fatalf("ClientIP: got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
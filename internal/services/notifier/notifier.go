@@ -0,0 +1,33 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/VladKvetkin/gophermart/internal/config"
+	"go.uber.org/zap"
+)
+
+// Notifier delivers messages to users, who are addressed by their login.
+type Notifier interface {
+	SendPasswordReset(ctx context.Context, login string, token string, expiresIn time.Duration) error
+}
+
+// NewNotifier returns the notifier chosen by config, the log notifier writes
+// to logger.
+func NewNotifier(config config.Config, logger *zap.Logger) Notifier {
+	if config.UseSMTPNotifier() {
+		return NewSMTPNotifier(config.SMTPAddress, config.SMTPFrom)
+	}
+
+	return NewLogNotifier(logger)
+}
+
+func passwordResetText(token string, expiresIn time.Duration) string {
+	return fmt.Sprintf(
+		"Use this token to reset your gophermart password: %s\r\n\r\n"+
+			"It can be used once and expires in %s. If you did not ask for a reset, ignore this message.\r\n",
+		token, expiresIn,
+	)
+}
@@ -0,0 +1,155 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// Argon2id parameters of new hashes, the second recommended option of
+	// RFC 9106 with a lower memory cost.
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 4
+	argonKeyLen  = 32
+	saltLen      = 16
+
+	argonPrefix = "$argon2id$"
+)
+
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+var (
+	// Every argon2id run takes argonMemory KiB, the number of parallel runs
+	// is bounded so a burst of logins or registrations cannot exhaust memory.
+	hashing = make(chan struct{}, runtime.NumCPU())
+
+	// Salt of the hash derived when there is no user to check a password
+	// against.
+	dummySalt = make([]byte, saltLen)
+)
+
+// Hash returns an argon2id hash of password with a random salt in the PHC
+// string format, e.g. $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>.
+func Hash(password string) (string, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := idKey([]byte(password), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+
+	return fmt.Sprintf(
+		"%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argonPrefix,
+		argon2.Version,
+		argonMemory,
+		argonTime,
+		argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// Verify reports whether password matches hash and whether hash should be
+// replaced by a fresh one: it is an unsalted SHA-256 hash of the first
+// version or its argon2id parameters are outdated.
+func Verify(password string, hash string) (ok bool, rehash bool, err error) {
+	if !strings.HasPrefix(hash, argonPrefix) {
+		return verifyLegacy(password, hash)
+	}
+
+	var (
+		version               int
+		memory, time, threads uint32
+		encodedSalt, encoded  string
+	)
+
+	parts := strings.Split(strings.TrimPrefix(hash, argonPrefix), "$")
+	if len(parts) != 4 {
+		return false, false, ErrUnknownHash
+	}
+
+	if _, err := fmt.Sscanf(parts[0], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, ErrUnknownHash
+	}
+
+	if _, err := fmt.Sscanf(parts[1], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil || threads == 0 || threads > 255 {
+		return false, false, ErrUnknownHash
+	}
+
+	encodedSalt, encoded = parts[2], parts[3]
+
+	salt, err := base64.RawStdEncoding.DecodeString(encodedSalt)
+	if err != nil {
+		return false, false, ErrUnknownHash
+	}
+
+	want, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(want) == 0 {
+		return false, false, ErrUnknownHash
+	}
+
+	got := idKey([]byte(password), salt, time, memory, uint8(threads), uint32(len(want)))
+	if subtle.ConstantTimeCompare(got, want) != 1 {
+		return false, false, nil
+	}
+
+	outdated := memory != argonMemory || time != argonTime || threads != argonThreads || len(want) != argonKeyLen || len(salt) != saltLen
+
+	return true, outdated, nil
+}
+
+// VerifyDummy takes as long as Verify of a current hash and always fails,
+// so a login that does not exist cannot be told apart by response time.
+func VerifyDummy(password string) {
+	idKey([]byte(password), dummySalt, argonTime, argonMemory, argonThreads, argonKeyLen)
+}
+
+func idKey(password []byte, salt []byte, time uint32, memory uint32, threads uint8, keyLen uint32) []byte {
+	hashing <- struct{}{}
+	defer func() { <-hashing }()
+
+	return argon2.IDKey(password, salt, time, memory, threads, keyLen)
+}
+
+func verifyLegacy(password string, hash string) (bool, bool, error) {
+	want, err := base64.StdEncoding.DecodeString(hash)
+	if err != nil || len(want) != sha256.Size {
+		return false, false, ErrUnknownHash
+	}
+
+	got := sha256.Sum256([]byte(password))
+	if subtle.ConstantTimeCompare(got[:], want) != 1 {
+		return false, false, nil
+	}
+
+	return true, true, nil
+}
+
+// NewResetToken returns a random password reset token for the user and its
+// hash, only the hash is stored.
+func NewResetToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	return token, HashResetToken(token), nil
+}
+
+func HashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// NewOrdersChannel is notified with the order number by a trigger on every
+// order insert, see migration 0006_new_order_notify.
+const NewOrdersChannel = "orders_new"
+
+type OrderListener struct {
+	databaseURI string
+}
+
+func NewOrderListener(databaseURI string) *OrderListener {
+	return &OrderListener{
+		databaseURI: databaseURI,
+	}
+}
+
+// Listen calls onOrder with the number of every order inserted by any
+// instance until ctx is done. Notifications sent while the connection is
+// being reestablished are lost, the periodic sweep picks such orders up.
+func (l *OrderListener) Listen(ctx context.Context, onOrder func(string)) error {
+	listener := pq.NewListener(l.databaseURI, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			zap.L().Info("error new orders listener: %w", zap.Error(err))
+		}
+	})
+
+	defer listener.Close()
+
+	if err := listener.Listen(NewOrdersChannel); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case notification := <-listener.Notify:
+			if notification != nil {
+				onOrder(notification.Extra)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,731 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/VladKvetkin/gophermart/internal/entities"
+)
+
+type memoryUser struct {
+	id           string
+	login        string
+	passwordHash string
+	role         string
+}
+
+func (u memoryUser) entity() entities.User {
+	return entities.User{ID: u.id, Login: u.login, PasswordHash: u.passwordHash, Role: u.role}
+}
+
+type loginAttempt struct {
+	failures      int
+	lastFailureAt time.Time
+	lockedUntil   time.Time
+}
+
+type passwordResetToken struct {
+	userID    string
+	expiresAt time.Time
+	used      bool
+}
+
+type MemoryStorage struct {
+	mu sync.RWMutex
+
+	users        map[string]memoryUser
+	userIDs      map[string]string
+	orders       []*entities.Order
+	orderNumbers map[string]*entities.Order
+	withdrawals  []entities.Withdrawal
+	withdrawNums map[string]struct{}
+	ledger       []entities.LedgerEntry
+	sessions     map[string]*entities.Session
+
+	loginAttempts map[string]*loginAttempt
+	resetTokens   map[string]*passwordResetToken
+
+	breakerState          string
+	breakerStateUpdatedAt time.Time
+}
+
+func NewMemoryStorage() Storage {
+	return &MemoryStorage{
+		users:        make(map[string]memoryUser),
+		userIDs:      make(map[string]string),
+		orderNumbers: make(map[string]*entities.Order),
+		withdrawNums: make(map[string]struct{}),
+		sessions:     make(map[string]*entities.Session),
+
+		loginAttempts: make(map[string]*loginAttempt),
+		resetTokens:   make(map[string]*passwordResetToken),
+	}
+}
+
+func (s *MemoryStorage) UpdateOrder(ctx context.Context, order entities.Order, accrual int, orderStatus string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.findOrderByID(order.ID)
+	if !ok {
+		return ErrNoRows
+	}
+
+	if stored.Status == orderStatus && entities.IsFinalOrderStatus(orderStatus) {
+		return nil
+	}
+
+	if err := entities.ValidateOrderTransition(stored.Status, orderStatus); err != nil {
+		return err
+	}
+
+	stored.Status = orderStatus
+	stored.Accrual = accrual
+	stored.UpdatedAt = s.now()
+	stored.LeaseExpiresAt = sql.NullTime{}
+	stored.Attempts = 0
+	stored.LastError = sql.NullString{}
+	stored.NextAttemptAt = stored.UpdatedAt
+
+	if orderStatus == entities.OrderStatusProcessed && accrual != 0 {
+		s.addLedgerEntry(stored.UserID, entities.LedgerEntryKindAccrual, accrual, stored.Number)
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) ClaimOrdersForAccrualer(ctx context.Context, limit int, lease time.Duration) ([]entities.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	var pending []*entities.Order
+	for _, order := range s.orders {
+		if claimable(order, now) {
+			pending = append(pending, order)
+		}
+	}
+
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].NextAttemptAt.Before(pending[j].NextAttemptAt)
+	})
+
+	if len(pending) > limit {
+		pending = pending[:limit]
+	}
+
+	claimed := make([]entities.Order, 0, len(pending))
+	for _, order := range pending {
+		order.LeaseExpiresAt = sql.NullTime{Time: now.Add(lease), Valid: true}
+		claimed = append(claimed, *order)
+	}
+
+	return claimed, nil
+}
+
+func (s *MemoryStorage) ClaimOrderForAccrualer(ctx context.Context, number string, lease time.Duration) (entities.Order, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	order, ok := s.orderNumbers[number]
+	if !ok || !claimable(order, now) {
+		return entities.Order{}, false, nil
+	}
+
+	order.LeaseExpiresAt = sql.NullTime{Time: now.Add(lease), Valid: true}
+
+	return *order, true, nil
+}
+
+func (s *MemoryStorage) ReleaseOrders(ctx context.Context, orders []entities.Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, order := range orders {
+		stored, ok := s.findOrderByID(order.ID)
+		if ok && stored.LeaseExpiresAt == order.LeaseExpiresAt {
+			stored.LeaseExpiresAt = sql.NullTime{}
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) RetryOrder(ctx context.Context, order entities.Order, lastError string, delay time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.findOrderByID(order.ID)
+	if !ok {
+		return ErrNoRows
+	}
+
+	stored.Attempts++
+	stored.LastError = sql.NullString{String: lastError, Valid: true}
+	stored.NextAttemptAt = s.now().Add(delay)
+	stored.LeaseExpiresAt = sql.NullTime{}
+
+	return nil
+}
+
+func (s *MemoryStorage) PostponeOrder(ctx context.Context, order entities.Order, lastError string, delay time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.findOrderByID(order.ID)
+	if !ok {
+		return ErrNoRows
+	}
+
+	stored.LastError = sql.NullString{String: lastError, Valid: true}
+	stored.NextAttemptAt = s.now().Add(delay)
+	stored.LeaseExpiresAt = sql.NullTime{}
+
+	return nil
+}
+
+func (s *MemoryStorage) InvalidateOrder(ctx context.Context, order entities.Order, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.findOrderByID(order.ID)
+	if !ok {
+		return ErrNoRows
+	}
+
+	if stored.Status == entities.OrderStatusInvalid {
+		return nil
+	}
+
+	if err := entities.ValidateOrderTransition(stored.Status, entities.OrderStatusInvalid); err != nil {
+		return err
+	}
+
+	stored.Status = entities.OrderStatusInvalid
+	stored.Attempts++
+	stored.LastError = sql.NullString{String: reason, Valid: true}
+	stored.UpdatedAt = s.now()
+	stored.LeaseExpiresAt = sql.NullTime{}
+
+	return nil
+}
+
+func (s *MemoryStorage) CreateWithdraw(ctx context.Context, userID string, orderNumber string, withdrawn int) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if withdrawn > s.userAccrual(userID) {
+		return "", ErrNotEnoughAccrual
+	}
+
+	if _, ok := s.withdrawNums[orderNumber]; ok {
+		return "", ErrConflict
+	}
+
+	withdrawal := entities.Withdrawal{
+		ID:        newID(),
+		Number:    orderNumber,
+		CreatedAt: s.now(),
+		UserID:    userID,
+		Withdrawn: withdrawn,
+	}
+
+	s.withdrawals = append(s.withdrawals, withdrawal)
+	s.withdrawNums[orderNumber] = struct{}{}
+	s.addLedgerEntry(userID, entities.LedgerEntryKindWithdrawal, -withdrawn, orderNumber)
+
+	return withdrawal.ID, nil
+}
+
+func (s *MemoryStorage) GetUserWithdrawals(ctx context.Context, userID string) ([]entities.Withdrawal, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var withdrawals []entities.Withdrawal
+	for _, withdrawal := range s.withdrawals {
+		if withdrawal.UserID == userID {
+			withdrawals = append(withdrawals, withdrawal)
+		}
+	}
+
+	return withdrawals, nil
+}
+
+func (s *MemoryStorage) GetUserLedger(ctx context.Context, userID string) ([]entities.LedgerEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var entries []entities.LedgerEntry
+	for _, entry := range s.ledger {
+		if entry.UserID == userID {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+func (s *MemoryStorage) GetUserAccrual(ctx context.Context, userID string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.userAccrual(userID), nil
+}
+
+func (s *MemoryStorage) GetUserWithdrawn(ctx context.Context, userID string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var withdrawn int
+	for _, entry := range s.ledger {
+		if entry.UserID == userID && entry.Kind == entities.LedgerEntryKindWithdrawal {
+			withdrawn -= entry.Amount
+		}
+	}
+
+	return withdrawn, nil
+}
+
+func (s *MemoryStorage) GetOrderByNumber(ctx context.Context, number string) (entities.Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	order, ok := s.orderNumbers[number]
+	if !ok {
+		return entities.Order{}, ErrNoRows
+	}
+
+	return *order, nil
+}
+
+func (s *MemoryStorage) GetOrCreateOrderIfNotExists(ctx context.Context, userID string, number string) (entities.Order, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if order, ok := s.orderNumbers[number]; ok {
+		return *order, false, nil
+	}
+
+	return s.createOrder(userID, number), true, nil
+}
+
+func (s *MemoryStorage) CreateOrder(ctx context.Context, userID string, number string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.orderNumbers[number]; ok {
+		return "", ErrConflict
+	}
+
+	return s.createOrder(userID, number).ID, nil
+}
+
+func (s *MemoryStorage) GetUserOrders(ctx context.Context, userID string) ([]entities.Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var orders []entities.Order
+	for _, order := range s.orders {
+		if order.UserID == userID {
+			orders = append(orders, *order)
+		}
+	}
+
+	return orders, nil
+}
+
+func (s *MemoryStorage) RecordLoginAttempt(
+	ctx context.Context,
+	key string,
+	window time.Duration,
+	lockout func(attempts int) time.Duration,
+) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	attempt, ok := s.loginAttempts[key]
+	if !ok {
+		attempt = &loginAttempt{}
+		s.loginAttempts[key] = attempt
+	}
+
+	if attempt.lockedUntil.After(now) {
+		return attempt.lockedUntil.Sub(now), nil
+	}
+
+	if attempt.lastFailureAt.Before(now.Add(-window)) {
+		attempt.failures = 0
+	}
+
+	attempt.failures++
+	attempt.lastFailureAt = now
+	attempt.lockedUntil = now.Add(lockout(attempt.failures))
+
+	return 0, nil
+}
+
+func (s *MemoryStorage) ForgetLoginAttempt(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempt, ok := s.loginAttempts[key]
+	if !ok {
+		return nil
+	}
+
+	if attempt.failures > 0 {
+		attempt.failures--
+	}
+
+	attempt.lockedUntil = time.Time{}
+
+	return nil
+}
+
+func (s *MemoryStorage) ResetLoginFailures(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.loginAttempts, key)
+
+	return nil
+}
+
+func (s *MemoryStorage) SaveAccrualBreakerState(ctx context.Context, state string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.breakerState = state
+	s.breakerStateUpdatedAt = s.now()
+
+	return nil
+}
+
+func (s *MemoryStorage) GetAccrualBreakerState(ctx context.Context, maxAge time.Duration) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.breakerState == "" || !s.breakerStateUpdatedAt.After(s.now().Add(-maxAge)) {
+		return "", ErrNoRows
+	}
+
+	return s.breakerState, nil
+}
+
+func (s *MemoryStorage) CreateSession(ctx context.Context, userID string, refreshTokenHash string, ttl time.Duration) (entities.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return entities.Session{}, ErrNoRows
+	}
+
+	if _, ok := s.findSessionByRefreshToken(refreshTokenHash); ok {
+		return entities.Session{}, ErrConflict
+	}
+
+	now := s.now()
+
+	session := &entities.Session{
+		ID:               newID(),
+		UserID:           userID,
+		RefreshTokenHash: refreshTokenHash,
+		CreatedAt:        now,
+		ExpiresAt:        now.Add(ttl),
+	}
+
+	s.sessions[session.ID] = session
+
+	return *session, nil
+}
+
+func (s *MemoryStorage) GetActiveSession(ctx context.Context, sessionID string) (entities.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[sessionID]
+	if !ok || !s.sessionActive(session) {
+		return entities.Session{}, ErrNoRows
+	}
+
+	return *session, nil
+}
+
+func (s *MemoryStorage) RotateSession(ctx context.Context, refreshTokenHash string, newRefreshTokenHash string, ttl time.Duration) (entities.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.findSessionByRefreshToken(refreshTokenHash)
+	if !ok || !s.sessionActive(session) {
+		return entities.Session{}, ErrNoRows
+	}
+
+	session.RefreshTokenHash = newRefreshTokenHash
+	session.ExpiresAt = s.now().Add(ttl)
+
+	return *session, nil
+}
+
+func (s *MemoryStorage) RevokeSession(ctx context.Context, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if session, ok := s.sessions[sessionID]; ok && !session.RevokedAt.Valid {
+		session.RevokedAt = sql.NullTime{Time: s.now(), Valid: true}
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) GetUserByLogin(ctx context.Context, login string) (entities.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	userID, ok := s.userIDs[login]
+	if !ok {
+		return entities.User{}, ErrNoRows
+	}
+
+	user := s.users[userID]
+
+	return user.entity(), nil
+}
+
+func (s *MemoryStorage) GetUserByID(ctx context.Context, userID string) (entities.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return entities.User{}, ErrNoRows
+	}
+
+	return user.entity(), nil
+}
+
+func (s *MemoryStorage) UpdateUserPassword(ctx context.Context, userID string, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return ErrNoRows
+	}
+
+	user.passwordHash = passwordHash
+	s.users[userID] = user
+
+	return nil
+}
+
+func (s *MemoryStorage) ChangeUserPassword(ctx context.Context, userID string, passwordHash string, keepSessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return ErrNoRows
+	}
+
+	user.passwordHash = passwordHash
+	s.users[userID] = user
+
+	s.revokeUserSessions(userID, keepSessionID)
+
+	return nil
+}
+
+func (s *MemoryStorage) SetUserRole(ctx context.Context, userID string, role string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return ErrNoRows
+	}
+
+	if user.role == role {
+		return nil
+	}
+
+	user.role = role
+	s.users[userID] = user
+
+	s.revokeUserSessions(userID, "")
+
+	return nil
+}
+
+func (s *MemoryStorage) CreatePasswordResetToken(ctx context.Context, userID string, tokenHash string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[userID]; !ok {
+		return ErrNoRows
+	}
+
+	if _, ok := s.resetTokens[tokenHash]; ok {
+		return ErrConflict
+	}
+
+	s.resetTokens[tokenHash] = &passwordResetToken{
+		userID:    userID,
+		expiresAt: s.now().Add(ttl),
+	}
+
+	return nil
+}
+
+func (s *MemoryStorage) ResetUserPassword(ctx context.Context, tokenHash string, passwordHash string) (entities.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	token, ok := s.resetTokens[tokenHash]
+	if !ok || token.used || !token.expiresAt.After(now) {
+		return entities.User{}, ErrNoRows
+	}
+
+	for _, other := range s.resetTokens {
+		if other.userID == token.userID {
+			other.used = true
+		}
+	}
+
+	user := s.users[token.userID]
+	user.passwordHash = passwordHash
+	s.users[token.userID] = user
+
+	s.revokeUserSessions(token.userID, "")
+
+	return user.entity(), nil
+}
+
+func (s *MemoryStorage) revokeUserSessions(userID string, keepSessionID string) {
+	for _, session := range s.sessions {
+		if session.UserID == userID && session.ID != keepSessionID && !session.RevokedAt.Valid {
+			session.RevokedAt = sql.NullTime{Time: s.now(), Valid: true}
+		}
+	}
+}
+
+func (s *MemoryStorage) CreateUser(ctx context.Context, login string, passwordHash string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.userIDs[login]; ok {
+		return "", ErrConflict
+	}
+
+	user := memoryUser{
+		id:           newID(),
+		login:        login,
+		passwordHash: passwordHash,
+		role:         entities.RoleUser,
+	}
+
+	s.users[user.id] = user
+	s.userIDs[login] = user.id
+
+	return user.id, nil
+}
+
+func (s *MemoryStorage) createOrder(userID string, number string) entities.Order {
+	now := s.now()
+
+	order := &entities.Order{
+		ID:            newID(),
+		Number:        number,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+		Status:        entities.OrderStatusNew,
+		UserID:        userID,
+		NextAttemptAt: now,
+	}
+
+	s.orders = append(s.orders, order)
+	s.orderNumbers[number] = order
+
+	return *order
+}
+
+func (s *MemoryStorage) findOrderByID(orderID string) (*entities.Order, bool) {
+	for _, order := range s.orders {
+		if order.ID == orderID {
+			return order, true
+		}
+	}
+
+	return nil, false
+}
+
+func (s *MemoryStorage) findSessionByRefreshToken(refreshTokenHash string) (*entities.Session, bool) {
+	for _, session := range s.sessions {
+		if session.RefreshTokenHash == refreshTokenHash {
+			return session, true
+		}
+	}
+
+	return nil, false
+}
+
+func (s *MemoryStorage) sessionActive(session *entities.Session) bool {
+	return !session.RevokedAt.Valid && session.ExpiresAt.After(s.now())
+}
+
+func claimable(order *entities.Order, now time.Time) bool {
+	if entities.IsFinalOrderStatus(order.Status) || order.NextAttemptAt.After(now) {
+		return false
+	}
+
+	return !order.LeaseExpiresAt.Valid || order.LeaseExpiresAt.Time.Before(now)
+}
+
+func (s *MemoryStorage) userAccrual(userID string) int {
+	var accrual int
+	for _, entry := range s.ledger {
+		if entry.UserID == userID {
+			accrual += entry.Amount
+		}
+	}
+
+	return accrual
+}
+
+func (s *MemoryStorage) addLedgerEntry(userID string, kind string, amount int, orderNumber string) {
+	s.ledger = append(s.ledger, entities.LedgerEntry{
+		ID:          newID(),
+		UserID:      userID,
+		Kind:        kind,
+		Amount:      amount,
+		OrderNumber: sql.NullString{String: orderNumber, Valid: orderNumber != ""},
+		CreatedAt:   s.now(),
+	})
+}
+
+func (s *MemoryStorage) now() time.Time {
+	return time.Now().UTC()
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
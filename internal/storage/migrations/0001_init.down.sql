@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS orders_withdraw;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS users(
+	id uuid DEFAULT gen_random_uuid() PRIMARY KEY,
+	login TEXT NOT NULL UNIQUE,
+	password TEXT NOT NULL,
+	bonuses INT DEFAULT 0,
+	withdrawn INT DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS orders(
+	id uuid DEFAULT gen_random_uuid() PRIMARY KEY,
+	number VARCHAR NOT NULL UNIQUE,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	status VARCHAR NOT NULL,
+	user_id uuid NOT NULL,
+	accrual INT DEFAULT 0,
+	CONSTRAINT fk_user FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS orders_withdraw(
+	id uuid DEFAULT gen_random_uuid() PRIMARY KEY,
+	number VARCHAR NOT NULL UNIQUE,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	user_id uuid NOT NULL,
+	withdrawn INT DEFAULT 0,
+	CONSTRAINT fk_user FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,9 @@
+ALTER TABLE users ADD COLUMN bonuses INT DEFAULT 0, ADD COLUMN withdrawn INT DEFAULT 0;
+
+UPDATE users u SET
+	bonuses = COALESCE((SELECT SUM(amount) FROM ledger_entries l WHERE l.user_id = u.id), 0),
+	withdrawn = COALESCE((SELECT -SUM(amount) FROM ledger_entries l WHERE l.user_id = u.id AND l.kind = 'WITHDRAWAL'), 0);
+
+DROP TABLE ledger_entries;
+DROP FUNCTION ledger_entries_check_balance();
+DROP FUNCTION ledger_entries_immutable();
@@ -0,0 +1,84 @@
+-- Every posting is single-sided: it moves points into or out of one user's
+-- account, the counterparty (the loyalty program) is implied and not booked.
+CREATE TABLE IF NOT EXISTS ledger_entries(
+	id uuid DEFAULT gen_random_uuid() PRIMARY KEY,
+	user_id uuid NOT NULL,
+	kind VARCHAR NOT NULL,
+	amount INT NOT NULL,
+	order_number VARCHAR,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	CONSTRAINT fk_user FOREIGN KEY(user_id) REFERENCES users(id),
+	CONSTRAINT amount_sign CHECK (
+		(kind = 'ACCRUAL' AND amount > 0) OR
+		(kind = 'WITHDRAWAL' AND amount < 0) OR
+		(kind = 'ADJUSTMENT' AND amount <> 0)
+	)
+);
+
+CREATE INDEX IF NOT EXISTS ledger_entries_user_id_idx ON ledger_entries(user_id);
+
+CREATE OR REPLACE FUNCTION ledger_entries_immutable() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'ledger entries are immutable';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ledger_entries_immutable ON ledger_entries;
+CREATE TRIGGER ledger_entries_immutable BEFORE UPDATE OR DELETE ON ledger_entries
+	FOR EACH ROW EXECUTE FUNCTION ledger_entries_immutable();
+
+-- Backfill postings from the counters that used to live on users. Databases
+-- that already went through this step no longer have the columns.
+DO $$
+BEGIN
+	IF EXISTS (
+		SELECT 1 FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = 'users' AND column_name = 'bonuses'
+	) THEN
+		INSERT INTO ledger_entries (user_id, kind, amount, order_number, created_at)
+		SELECT user_id, 'ACCRUAL', accrual, number, updated_at FROM orders WHERE accrual > 0;
+
+		INSERT INTO ledger_entries (user_id, kind, amount, order_number, created_at)
+		SELECT user_id, 'WITHDRAWAL', -withdrawn, number, created_at FROM orders_withdraw WHERE withdrawn > 0;
+
+		-- The withdrawn counter can only be explained by withdrawals that are
+		-- missing from orders_withdraw, they are booked without an order. A
+		-- counter below the recorded withdrawals cannot be explained at all.
+		IF EXISTS (
+			SELECT 1 FROM users u
+			WHERE COALESCE(u.withdrawn, 0) < (SELECT COALESCE(SUM(w.withdrawn), 0) FROM orders_withdraw w WHERE w.user_id = u.id)
+		) THEN
+			RAISE EXCEPTION 'users.withdrawn is below the sum of orders_withdraw for some users, reconcile them before migrating';
+		END IF;
+
+		INSERT INTO ledger_entries (user_id, kind, amount)
+		SELECT u.id, 'WITHDRAWAL', -(COALESCE(u.withdrawn, 0) - COALESCE(SUM(w.withdrawn), 0))
+		FROM users u LEFT JOIN orders_withdraw w ON w.user_id = u.id
+		GROUP BY u.id, u.withdrawn
+		HAVING COALESCE(u.withdrawn, 0) - COALESCE(SUM(w.withdrawn), 0) > 0;
+
+		INSERT INTO ledger_entries (user_id, kind, amount)
+		SELECT u.id, 'ADJUSTMENT', COALESCE(u.bonuses, 0) - COALESCE(SUM(l.amount), 0)
+		FROM users u LEFT JOIN ledger_entries l ON l.user_id = u.id
+		GROUP BY u.id, u.bonuses
+		HAVING COALESCE(u.bonuses, 0) - COALESCE(SUM(l.amount), 0) <> 0;
+
+		ALTER TABLE users DROP COLUMN bonuses, DROP COLUMN withdrawn;
+	END IF;
+END $$;
+
+CREATE OR REPLACE FUNCTION ledger_entries_check_balance() RETURNS trigger AS $$
+BEGIN
+	IF (SELECT SUM(amount) FROM ledger_entries WHERE user_id = NEW.user_id) < 0 THEN
+		RAISE EXCEPTION 'negative balance for user %', NEW.user_id USING ERRCODE = 'check_violation';
+	END IF;
+
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Withdrawals and negative adjustments alike must not take a balance below
+-- zero.
+DROP TRIGGER IF EXISTS ledger_entries_check_balance ON ledger_entries;
+CREATE TRIGGER ledger_entries_check_balance AFTER INSERT ON ledger_entries
+	FOR EACH ROW WHEN (NEW.amount < 0) EXECUTE FUNCTION ledger_entries_check_balance();
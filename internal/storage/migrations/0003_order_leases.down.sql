@@ -0,0 +1,3 @@
+DROP INDEX orders_pending_idx;
+
+ALTER TABLE orders DROP COLUMN lease_expires_at;
@@ -0,0 +1,3 @@
+ALTER TABLE orders ADD COLUMN lease_expires_at TIMESTAMP;
+
+CREATE INDEX orders_pending_idx ON orders(updated_at) WHERE status NOT IN ('PROCESSED', 'INVALID');
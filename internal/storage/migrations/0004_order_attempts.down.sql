@@ -0,0 +1,8 @@
+DROP INDEX orders_pending_idx;
+
+CREATE INDEX orders_pending_idx ON orders(updated_at) WHERE status NOT IN ('PROCESSED', 'INVALID');
+
+ALTER TABLE orders
+	DROP COLUMN attempts,
+	DROP COLUMN last_error,
+	DROP COLUMN next_attempt_at;
@@ -0,0 +1,8 @@
+ALTER TABLE orders
+	ADD COLUMN attempts INT NOT NULL DEFAULT 0,
+	ADD COLUMN last_error TEXT,
+	ADD COLUMN next_attempt_at TIMESTAMP NOT NULL DEFAULT LOCALTIMESTAMP;
+
+DROP INDEX orders_pending_idx;
+
+CREATE INDEX orders_pending_idx ON orders(next_attempt_at) WHERE status NOT IN ('PROCESSED', 'INVALID');
@@ -0,0 +1,27 @@
+-- Orders credited more than once keep their first ACCRUAL posting, every
+-- extra one is reversed by an ADJUSTMENT: the ledger is append-only, so the
+-- extra postings cannot be deleted. A user who already spent a double credit
+-- is left with a negative balance, the balance check would refuse that.
+ALTER TABLE ledger_entries DISABLE TRIGGER ledger_entries_check_balance;
+
+INSERT INTO ledger_entries (user_id, kind, amount, order_number)
+SELECT user_id, 'ADJUSTMENT', -amount, order_number
+FROM (
+	SELECT user_id, amount, order_number,
+		ROW_NUMBER() OVER (PARTITION BY order_number ORDER BY created_at, id) AS n
+	FROM ledger_entries
+	WHERE kind = 'ACCRUAL'
+) accruals
+WHERE n > 1;
+
+ALTER TABLE ledger_entries ENABLE TRIGGER ledger_entries_check_balance;
+
+-- The reversed postings stay, so an index over ACCRUAL postings cannot be
+-- unique. Orders are marked as credited here instead.
+CREATE TABLE order_accruals(
+	order_number VARCHAR PRIMARY KEY,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO order_accruals (order_number)
+SELECT DISTINCT order_number FROM ledger_entries WHERE kind = 'ACCRUAL';
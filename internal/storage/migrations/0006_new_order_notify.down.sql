@@ -0,0 +1,2 @@
+DROP TRIGGER orders_notify_new ON orders;
+DROP FUNCTION orders_notify_new();
@@ -0,0 +1,10 @@
+CREATE OR REPLACE FUNCTION orders_notify_new() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_notify('orders_new', NEW.number);
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER orders_notify_new
+	AFTER INSERT ON orders
+	FOR EACH ROW EXECUTE FUNCTION orders_notify_new();
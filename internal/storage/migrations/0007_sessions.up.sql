@@ -0,0 +1,11 @@
+CREATE TABLE sessions(
+	id uuid DEFAULT gen_random_uuid() PRIMARY KEY,
+	user_id uuid NOT NULL,
+	refresh_token_hash TEXT NOT NULL UNIQUE,
+	created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL,
+	revoked_at TIMESTAMP,
+	CONSTRAINT fk_user FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX sessions_user_id_idx ON sessions(user_id);
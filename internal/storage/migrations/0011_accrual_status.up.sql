@@ -0,0 +1,7 @@
+-- A single row with the accrual system state seen by the replica running the
+-- accrualer, so every replica can report it.
+CREATE TABLE accrual_status(
+	id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+	breaker_state TEXT NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+);
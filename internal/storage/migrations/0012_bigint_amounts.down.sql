@@ -0,0 +1,3 @@
+ALTER TABLE orders_withdraw ALTER COLUMN withdrawn TYPE INT;
+ALTER TABLE orders ALTER COLUMN accrual TYPE INT;
+ALTER TABLE ledger_entries ALTER COLUMN amount TYPE INT;
@@ -0,0 +1,5 @@
+-- Amounts are kept in cents as money.Amount, an int64, so INT columns
+-- overflow past 21474836.47 points.
+ALTER TABLE ledger_entries ALTER COLUMN amount TYPE BIGINT;
+ALTER TABLE orders ALTER COLUMN accrual TYPE BIGINT;
+ALTER TABLE orders_withdraw ALTER COLUMN withdrawn TYPE BIGINT;
@@ -7,9 +7,12 @@ import (
 	"time"
 
 	"github.com/VladKvetkin/gophermart/internal/entities"
+	"github.com/VladKvetkin/gophermart/internal/migrator"
+	"github.com/VladKvetkin/gophermart/internal/storage/migrations"
 	"github.com/jackc/pgerrcode"
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
+	"go.uber.org/zap"
 )
 
 var (
@@ -19,21 +22,45 @@ var (
 )
 
 type Storage interface {
-	GetUser(context.Context, string, string) (string, error)
+	GetUserByLogin(context.Context, string) (entities.User, error)
+	GetUserByID(context.Context, string) (entities.User, error)
 	GetUserOrders(context.Context, string) ([]entities.Order, error)
+	GetOrderByNumber(context.Context, string) (entities.Order, error)
 	GetOrCreateOrderIfNotExists(context.Context, string, string) (entities.Order, bool, error)
 	GetUserAccrual(context.Context, string) (int, error)
 	GetUserWithdrawn(context.Context, string) (int, error)
 	GetUserWithdrawals(context.Context, string) ([]entities.Withdrawal, error)
+	GetUserLedger(context.Context, string) ([]entities.LedgerEntry, error)
 
 	CreateUser(context.Context, string, string) (string, error)
+	UpdateUserPassword(context.Context, string, string) error
+	ChangeUserPassword(context.Context, string, string, string) error
+	SetUserRole(context.Context, string, string) error
+
+	CreatePasswordResetToken(context.Context, string, string, time.Duration) error
+	ResetUserPassword(context.Context, string, string) (entities.User, error)
+
+	CreateSession(context.Context, string, string, time.Duration) (entities.Session, error)
+	GetActiveSession(context.Context, string) (entities.Session, error)
+	RotateSession(context.Context, string, string, time.Duration) (entities.Session, error)
+	RevokeSession(context.Context, string) error
+
+	RecordLoginAttempt(context.Context, string, time.Duration, func(int) time.Duration) (time.Duration, error)
+	ForgetLoginAttempt(context.Context, string) error
+	ResetLoginFailures(context.Context, string) error
 	CreateOrder(context.Context, string, string) (string, error)
 	CreateWithdraw(context.Context, string, string, int) (string, error)
 
-	GetOrdersForAccrualer(context.Context, int, int) ([]entities.Order, error)
+	ClaimOrdersForAccrualer(context.Context, int, time.Duration) ([]entities.Order, error)
+	ClaimOrderForAccrualer(context.Context, string, time.Duration) (entities.Order, bool, error)
+	ReleaseOrders(context.Context, []entities.Order) error
 	UpdateOrder(context.Context, entities.Order, int, string) error
+	RetryOrder(context.Context, entities.Order, string, time.Duration) error
+	PostponeOrder(context.Context, entities.Order, string, time.Duration) error
+	InvalidateOrder(context.Context, entities.Order, string) error
 
-	runMigrations(context.Context) error
+	SaveAccrualBreakerState(context.Context, string) error
+	GetAccrualBreakerState(context.Context, time.Duration) (string, error)
 }
 
 type PostgresStorage struct {
@@ -41,37 +68,62 @@ type PostgresStorage struct {
 }
 
 func NewPostgresStorage(db *sqlx.DB) (Storage, error) {
-	storage := &PostgresStorage{db: db}
+	m, err := migrator.NewMigrator(db, migrations.FS)
+	if err != nil {
+		return nil, err
+	}
 
-	err := storage.runMigrations(context.Background())
+	applied, err := m.Up(context.Background())
 	if err != nil {
 		return nil, err
 	}
 
-	return storage, nil
+	for _, migration := range applied {
+		zap.L().Info("applied migration", zap.Int("version", migration.Version), zap.String("name", migration.Name))
+	}
+
+	return &PostgresStorage{db: db}, nil
 }
 
 func (s *PostgresStorage) UpdateOrder(ctx context.Context, order entities.Order, accrual int, orderStatus string) error {
-	tx, err := s.db.Beginx()
+	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
 	defer tx.Rollback()
 
-	if _, err := tx.ExecContext(
+	result, err := tx.ExecContext(
 		ctx,
-		`UPDATE orders SET status = $1, accrual = $2, updated_at=$3::timestamp WHERE id = $4;`,
+		`UPDATE orders SET status = $1, accrual = $2, updated_at=$3::timestamp,
+			lease_expires_at = NULL, attempts = 0, last_error = NULL, next_attempt_at = LOCALTIMESTAMP
+		WHERE id = $4 AND status = ANY($5);`,
 		orderStatus, accrual, time.Now().UTC().Format(time.RFC3339), order.ID,
-	); err != nil {
+		pq.Array(entities.OrderStatusesTransitableTo(orderStatus)),
+	)
+	if err != nil {
+		return err
+	}
+
+	updated, err := s.checkTransition(ctx, tx, result, order.ID, orderStatus)
+	if err != nil {
 		return err
 	}
 
-	if accrual != 0 {
+	// Only the update that moved the order into PROCESSED credits it, a
+	// repeated delivery of the same result changes nothing. Marking the order
+	// in order_accruals backs this up.
+	if updated && orderStatus == entities.OrderStatusProcessed && accrual != 0 {
 		if _, err := tx.ExecContext(
 			ctx,
-			`UPDATE users SET bonuses = bonuses + $1 WHERE id = $2;`,
-			accrual, order.UserID,
+			`WITH credited AS (
+				INSERT INTO order_accruals (order_number) VALUES ($4)
+				ON CONFLICT DO NOTHING
+				RETURNING order_number
+			)
+			INSERT INTO ledger_entries (user_id, kind, amount, order_number)
+			SELECT $1, $2, $3, order_number FROM credited;`,
+			order.UserID, entities.LedgerEntryKindAccrual, accrual, order.Number,
 		); err != nil {
 			return err
 		}
@@ -80,17 +132,137 @@ func (s *PostgresStorage) UpdateOrder(ctx context.Context, order entities.Order,
 	return tx.Commit()
 }
 
-func (s *PostgresStorage) GetOrdersForAccrualer(ctx context.Context, offset int, limit int) ([]entities.Order, error) {
+func (s *PostgresStorage) RetryOrder(ctx context.Context, order entities.Order, lastError string, delay time.Duration) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`UPDATE orders SET attempts = attempts + 1, last_error = $1,
+			next_attempt_at = LOCALTIMESTAMP + make_interval(secs => $2), lease_expires_at = NULL
+		WHERE id = $3;`,
+		lastError, delay.Seconds(), order.ID,
+	)
+
+	return err
+}
+
+// PostponeOrder schedules another check like RetryOrder without counting
+// an attempt, for checks that failed on the accrual system side.
+func (s *PostgresStorage) PostponeOrder(ctx context.Context, order entities.Order, lastError string, delay time.Duration) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`UPDATE orders SET last_error = $1,
+			next_attempt_at = LOCALTIMESTAMP + make_interval(secs => $2), lease_expires_at = NULL
+		WHERE id = $3;`,
+		lastError, delay.Seconds(), order.ID,
+	)
+
+	return err
+}
+
+func (s *PostgresStorage) InvalidateOrder(ctx context.Context, order entities.Order, reason string) error {
+	result, err := s.db.ExecContext(
+		ctx,
+		`UPDATE orders SET status = $1, attempts = attempts + 1, last_error = $2,
+			updated_at = $3::timestamp, lease_expires_at = NULL
+		WHERE id = $4 AND status = ANY($5);`,
+		entities.OrderStatusInvalid, reason, time.Now().UTC().Format(time.RFC3339), order.ID,
+		pq.Array(entities.OrderStatusesTransitableTo(entities.OrderStatusInvalid)),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.checkTransition(ctx, s.db, result, order.ID, entities.OrderStatusInvalid)
+
+	return err
+}
+
+// SaveAccrualBreakerState publishes the state to every replica.
+func (s *PostgresStorage) SaveAccrualBreakerState(ctx context.Context, state string) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		`INSERT INTO accrual_status (breaker_state, updated_at) VALUES ($1, LOCALTIMESTAMP)
+		ON CONFLICT (id) DO UPDATE SET breaker_state = EXCLUDED.breaker_state, updated_at = EXCLUDED.updated_at;`,
+		state,
+	)
+
+	return err
+}
+
+// GetAccrualBreakerState returns ErrNoRows when no state was saved during
+// the last maxAge.
+func (s *PostgresStorage) GetAccrualBreakerState(ctx context.Context, maxAge time.Duration) (string, error) {
+	var state string
+
+	err := s.db.GetContext(
+		ctx,
+		&state,
+		"SELECT breaker_state FROM accrual_status WHERE updated_at > LOCALTIMESTAMP - make_interval(secs => $1);",
+		maxAge.Seconds(),
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRows
+		}
+
+		return "", err
+	}
+
+	return state, nil
+}
+
+// checkTransition reports whether a conditional status update changed the
+// order. When it matched no row the order is either already in the target
+// final status, which is not an error, or the transition is forbidden.
+func (s *PostgresStorage) checkTransition(ctx context.Context, q sqlx.QueryerContext, result sql.Result, orderID string, to string) (bool, error) {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	if affected != 0 {
+		return true, nil
+	}
+
+	var from string
+	if err := sqlx.GetContext(ctx, q, &from, "SELECT status FROM orders WHERE id = $1;", orderID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrNoRows
+		}
+
+		return false, err
+	}
+
+	if from == to {
+		return false, nil
+	}
+
+	return false, &entities.TransitionError{From: from, To: to}
+}
+
+// Orders locked by a concurrent claim are skipped. A lease not released by
+// UpdateOrder, e.g. because the instance died, can be claimed again once it expires.
+func (s *PostgresStorage) ClaimOrdersForAccrualer(ctx context.Context, limit int, lease time.Duration) ([]entities.Order, error) {
 	var orders []entities.Order
 
 	err := s.db.SelectContext(
 		ctx,
 		&orders,
-		"SELECT * FROM orders WHERE status NOT IN ($1,$2) ORDER BY updated_at ASC LIMIT $3 OFFSET $4;",
+		`UPDATE orders SET lease_expires_at = LOCALTIMESTAMP + make_interval(secs => $1)
+		WHERE id IN (
+			SELECT id FROM orders
+			WHERE status NOT IN ($2, $3)
+				AND next_attempt_at <= LOCALTIMESTAMP
+				AND (lease_expires_at IS NULL OR lease_expires_at < LOCALTIMESTAMP)
+			ORDER BY next_attempt_at ASC
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING *;`,
+		lease.Seconds(),
 		entities.OrderStatusProcessed,
 		entities.OrderStatusInvalid,
 		limit,
-		offset,
 	)
 
 	if err != nil {
@@ -100,15 +272,75 @@ func (s *PostgresStorage) GetOrdersForAccrualer(ctx context.Context, offset int,
 	return orders, nil
 }
 
+func (s *PostgresStorage) ClaimOrderForAccrualer(ctx context.Context, number string, lease time.Duration) (entities.Order, bool, error) {
+	var order entities.Order
+
+	err := s.db.GetContext(
+		ctx,
+		&order,
+		`UPDATE orders SET lease_expires_at = LOCALTIMESTAMP + make_interval(secs => $1)
+		WHERE number = $2
+			AND status NOT IN ($3, $4)
+			AND next_attempt_at <= LOCALTIMESTAMP
+			AND (lease_expires_at IS NULL OR lease_expires_at < LOCALTIMESTAMP)
+		RETURNING *;`,
+		lease.Seconds(),
+		number,
+		entities.OrderStatusProcessed,
+		entities.OrderStatusInvalid,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entities.Order{}, false, nil
+		}
+
+		return entities.Order{}, false, err
+	}
+
+	return order, true, nil
+}
+
+// ReleaseOrders gives up the leases of orders claimed but left unprocessed,
+// so any instance can claim them right away. A lease that has been taken
+// over since is left alone.
+func (s *PostgresStorage) ReleaseOrders(ctx context.Context, orders []entities.Order) error {
+	var (
+		ids    = make([]string, 0, len(orders))
+		leases = make([]string, 0, len(orders))
+	)
+
+	for _, order := range orders {
+		ids = append(ids, order.ID)
+		leases = append(leases, order.LeaseExpiresAt.Time.Format("2006-01-02 15:04:05.999999"))
+	}
+
+	_, err := s.db.ExecContext(
+		ctx,
+		`UPDATE orders SET lease_expires_at = NULL
+		FROM unnest($1::uuid[], $2::timestamp[]) AS released(id, lease_expires_at)
+		WHERE orders.id = released.id AND orders.lease_expires_at = released.lease_expires_at;`,
+		pq.Array(ids), pq.Array(leases),
+	)
+
+	return err
+}
+
 func (s *PostgresStorage) CreateWithdraw(ctx context.Context, userID string, orderNumber string, withdrawn int) (string, error) {
-	tx, err := s.db.Beginx()
+	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return "", err
 	}
 
 	defer tx.Rollback()
 
-	currentAccrual, err := s.GetUserAccrual(ctx, userID)
+	// Lock the user row so concurrent withdrawals of the same user are
+	// serialized and each one sees the balance left by the previous.
+	if _, err := tx.ExecContext(ctx, "SELECT id FROM users WHERE id = $1 FOR UPDATE;", userID); err != nil {
+		return "", err
+	}
+
+	currentAccrual, err := s.getUserAccrual(ctx, tx, userID)
 	if err != nil {
 		return "", err
 	}
@@ -127,6 +359,11 @@ func (s *PostgresStorage) CreateWithdraw(ctx context.Context, userID string, ord
 	)
 
 	if err := row.Err(); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pgerrcode.IsIntegrityConstraintViolation(string(pqErr.Code)) {
+			return "", ErrConflict
+		}
+
 		return "", err
 	}
 
@@ -134,8 +371,18 @@ func (s *PostgresStorage) CreateWithdraw(ctx context.Context, userID string, ord
 		return "", err
 	}
 
-	_, err = tx.ExecContext(ctx, `UPDATE users SET bonuses=bonuses-$1, withdrawn=withdrawn+$1 WHERE id=$2`, withdrawn, userID)
+	_, err = tx.ExecContext(
+		ctx,
+		`INSERT INTO ledger_entries (user_id, kind, amount, order_number)
+		VALUES ($1, $2, $3, $4);`,
+		userID, entities.LedgerEntryKindWithdrawal, -withdrawn, orderNumber,
+	)
 	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pgerrcode.CheckViolation {
+			return "", ErrNotEnoughAccrual
+		}
+
 		return "", err
 	}
 
@@ -158,10 +405,25 @@ func (s *PostgresStorage) GetUserWithdrawals(ctx context.Context, userID string)
 	return withdrawals, nil
 }
 
+func (s *PostgresStorage) GetUserLedger(ctx context.Context, userID string) ([]entities.LedgerEntry, error) {
+	var entries []entities.LedgerEntry
+
+	err := s.db.SelectContext(ctx, &entries, "SELECT * FROM ledger_entries WHERE user_id = $1 ORDER BY created_at ASC;", userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
 func (s *PostgresStorage) GetUserAccrual(ctx context.Context, userID string) (int, error) {
+	return s.getUserAccrual(ctx, s.db, userID)
+}
+
+func (s *PostgresStorage) getUserAccrual(ctx context.Context, q sqlx.QueryerContext, userID string) (int, error) {
 	var accrual int
 
-	row := s.db.QueryRowxContext(ctx, "SELECT bonuses FROM users WHERE id = $1;", userID)
+	row := q.QueryRowxContext(ctx, "SELECT COALESCE(SUM(amount), 0) FROM ledger_entries WHERE user_id = $1;", userID)
 
 	if err := row.Err(); err != nil {
 		return 0, err
@@ -181,7 +443,11 @@ func (s *PostgresStorage) GetUserAccrual(ctx context.Context, userID string) (in
 func (s *PostgresStorage) GetUserWithdrawn(ctx context.Context, userID string) (int, error) {
 	var withdrawn int
 
-	row := s.db.QueryRowxContext(ctx, "SELECT withdrawn FROM users WHERE id = $1;", userID)
+	row := s.db.QueryRowxContext(
+		ctx,
+		"SELECT COALESCE(-SUM(amount), 0) FROM ledger_entries WHERE user_id = $1 AND kind = $2;",
+		userID, entities.LedgerEntryKindWithdrawal,
+	)
 
 	if err := row.Err(); err != nil {
 		return 0, err
@@ -198,6 +464,20 @@ func (s *PostgresStorage) GetUserWithdrawn(ctx context.Context, userID string) (
 	return withdrawn, nil
 }
 
+func (s *PostgresStorage) GetOrderByNumber(ctx context.Context, number string) (entities.Order, error) {
+	var order entities.Order
+
+	if err := s.db.GetContext(ctx, &order, "SELECT * FROM orders WHERE number = $1;", number); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entities.Order{}, ErrNoRows
+		}
+
+		return entities.Order{}, err
+	}
+
+	return order, nil
+}
+
 func (s *PostgresStorage) GetOrCreateOrderIfNotExists(ctx context.Context, userID string, number string) (entities.Order, bool, error) {
 	var order entities.Order
 
@@ -282,7 +562,7 @@ func (s *PostgresStorage) CreateOrder(ctx context.Context, userID string, number
 func (s *PostgresStorage) GetUserOrders(ctx context.Context, userID string) ([]entities.Order, error) {
 	var orders []entities.Order
 
-	err := s.db.SelectContext(ctx, &orders, "SELECT * FROM orders WHERE user_id = $1;", userID)
+	err := s.db.SelectContext(ctx, &orders, "SELECT * FROM orders WHERE user_id = $1 ORDER BY created_at ASC;", userID)
 	if err != nil {
 		return nil, err
 	}
@@ -290,116 +570,399 @@ func (s *PostgresStorage) GetUserOrders(ctx context.Context, userID string) ([]e
 	return orders, nil
 }
 
-func (s *PostgresStorage) GetUser(ctx context.Context, login string, passwordHash string) (string, error) {
-	var userID string
+// RecordLoginAttempt counts an attempt for key unless key is locked, then it
+// returns the time left. The attempt is counted as failed and key is locked
+// for lockout(attempts) right away, so parallel attempts cannot slip past a
+// limit before the outcome of the earlier ones is known. Attempts are
+// forgotten once the last one is older than window.
+func (s *PostgresStorage) RecordLoginAttempt(
+	ctx context.Context,
+	key string,
+	window time.Duration,
+	lockout func(attempts int) time.Duration,
+) (time.Duration, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
 
-	row := s.db.QueryRowxContext(ctx, "SELECT id FROM users WHERE login = $1 AND password = $2;", login, passwordHash)
+	defer tx.Rollback()
 
-	if err := row.Err(); err != nil {
-		return "", err
+	var attempt struct {
+		Failures  int     `db:"failures"`
+		Expired   bool    `db:"expired"`
+		LockedFor float64 `db:"locked_for"`
 	}
 
-	err := row.Scan(&userID)
+	// The no-op update locks the row of key until the transaction ends.
+	err = tx.GetContext(
+		ctx,
+		&attempt,
+		`INSERT INTO login_attempts (key, failures, last_failure_at) VALUES ($1, 0, LOCALTIMESTAMP)
+		ON CONFLICT (key) DO UPDATE SET key = EXCLUDED.key
+		RETURNING
+			failures,
+			last_failure_at < LOCALTIMESTAMP - make_interval(secs => $2) AS expired,
+			COALESCE(EXTRACT(EPOCH FROM locked_until - LOCALTIMESTAMP), 0) AS locked_for;`,
+		key, window.Seconds(),
+	)
+
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return "", ErrNoRows
-		}
+		return 0, err
+	}
 
-		return "", err
+	if attempt.LockedFor > 0 {
+		return time.Duration(attempt.LockedFor * float64(time.Second)), nil
 	}
 
-	return userID, nil
+	failures := attempt.Failures + 1
+	if attempt.Expired {
+		failures = 1
+	}
+
+	if _, err := tx.ExecContext(
+		ctx,
+		`UPDATE login_attempts
+		SET failures = $2, last_failure_at = LOCALTIMESTAMP, locked_until = LOCALTIMESTAMP + make_interval(secs => $3)
+		WHERE key = $1;`,
+		key, failures, lockout(failures).Seconds(),
+	); err != nil {
+		return 0, err
+	}
+
+	return 0, tx.Commit()
 }
 
-func (s *PostgresStorage) CreateUser(ctx context.Context, login string, passwordHash string) (string, error) {
-	var userID string
+// ForgetLoginAttempt takes back an attempt of key that turned out to succeed
+// or was refused for another key, along with the lockout it set.
+func (s *PostgresStorage) ForgetLoginAttempt(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		"UPDATE login_attempts SET failures = GREATEST(failures - 1, 0), locked_until = NULL WHERE key = $1;",
+		key,
+	)
+	return err
+}
 
-	row := s.db.QueryRowxContext(
+func (s *PostgresStorage) ResetLoginFailures(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM login_attempts WHERE key = $1;", key)
+	return err
+}
+
+func (s *PostgresStorage) CreateSession(ctx context.Context, userID string, refreshTokenHash string, ttl time.Duration) (entities.Session, error) {
+	var session entities.Session
+
+	err := s.db.GetContext(
 		ctx,
-		`INSERT INTO users (login, password)
-		VALUES ($1, $2) RETURNING id;`,
-		login, passwordHash,
+		&session,
+		`INSERT INTO sessions (user_id, refresh_token_hash, expires_at)
+		VALUES ($1, $2, LOCALTIMESTAMP + make_interval(secs => $3))
+		RETURNING *;`,
+		userID, refreshTokenHash, ttl.Seconds(),
 	)
 
-	if err := row.Err(); err != nil {
+	if err != nil {
 		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pgerrcode.ForeignKeyViolation {
+			return entities.Session{}, ErrNoRows
+		}
+
 		if errors.As(err, &pqErr) && pgerrcode.IsIntegrityConstraintViolation(string(pqErr.Code)) {
-			return "", ErrConflict
+			return entities.Session{}, ErrConflict
+		}
+
+		return entities.Session{}, err
+	}
+
+	return session, nil
+}
+
+func (s *PostgresStorage) GetActiveSession(ctx context.Context, sessionID string) (entities.Session, error) {
+	var session entities.Session
+
+	err := s.db.GetContext(
+		ctx,
+		&session,
+		"SELECT * FROM sessions WHERE id = $1 AND revoked_at IS NULL AND expires_at > LOCALTIMESTAMP;",
+		sessionID,
+	)
 
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entities.Session{}, ErrNoRows
 		}
 
-		return "", err
+		return entities.Session{}, err
 	}
 
-	if err := row.Scan(&userID); err != nil {
-		return "", err
+	return session, nil
+}
+
+func (s *PostgresStorage) RotateSession(ctx context.Context, refreshTokenHash string, newRefreshTokenHash string, ttl time.Duration) (entities.Session, error) {
+	var session entities.Session
+
+	// Matching on the old hash makes concurrent refreshes with the same
+	// token race for a single winner.
+	err := s.db.GetContext(
+		ctx,
+		&session,
+		`UPDATE sessions SET refresh_token_hash = $1, expires_at = LOCALTIMESTAMP + make_interval(secs => $2)
+		WHERE refresh_token_hash = $3 AND revoked_at IS NULL AND expires_at > LOCALTIMESTAMP
+		RETURNING *;`,
+		newRefreshTokenHash, ttl.Seconds(), refreshTokenHash,
+	)
+
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entities.Session{}, ErrNoRows
+		}
+
+		return entities.Session{}, err
 	}
 
-	return userID, nil
+	return session, nil
 }
 
-func (s *PostgresStorage) runMigrations(ctx context.Context) error {
-	tx, err := s.db.Beginx()
+func (s *PostgresStorage) RevokeSession(ctx context.Context, sessionID string) error {
+	_, err := s.db.ExecContext(
+		ctx,
+		"UPDATE sessions SET revoked_at = LOCALTIMESTAMP WHERE id = $1 AND revoked_at IS NULL;",
+		sessionID,
+	)
+
+	return err
+}
+
+func (s *PostgresStorage) GetUserByLogin(ctx context.Context, login string) (entities.User, error) {
+	var user entities.User
+
+	if err := s.db.GetContext(ctx, &user, "SELECT id, login, password, role FROM users WHERE login = $1;", login); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entities.User{}, ErrNoRows
+		}
+
+		return entities.User{}, err
+	}
+
+	return user, nil
+}
+
+func (s *PostgresStorage) GetUserByID(ctx context.Context, userID string) (entities.User, error) {
+	var user entities.User
+
+	if err := s.db.GetContext(ctx, &user, "SELECT id, login, password, role FROM users WHERE id = $1;", userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entities.User{}, ErrNoRows
+		}
+
+		return entities.User{}, err
+	}
+
+	return user, nil
+}
+
+func (s *PostgresStorage) UpdateUserPassword(ctx context.Context, userID string, passwordHash string) error {
+	result, err := s.db.ExecContext(ctx, "UPDATE users SET password = $1 WHERE id = $2;", passwordHash, userID)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return ErrNoRows
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) ChangeUserPassword(ctx context.Context, userID string, passwordHash string, keepSessionID string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
 	defer tx.Rollback()
 
-	_, err = tx.ExecContext(
-		ctx,
-		`
-		CREATE TABLE IF NOT EXISTS users(
-			id uuid DEFAULT gen_random_uuid() PRIMARY KEY,
-			login TEXT NOT NULL UNIQUE,
-			password TEXT NOT NULL,
-			bonuses INT DEFAULT 0,
-			withdrawn INT DEFAULT 0
-		);
-		`,
-	)
+	result, err := tx.ExecContext(ctx, "UPDATE users SET password = $1 WHERE id = $2;", passwordHash, userID)
+	if err != nil {
+		return err
+	}
 
+	updated, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.ExecContext(
+	if updated == 0 {
+		return ErrNoRows
+	}
+
+	if err := s.revokeUserSessions(ctx, tx, userID, keepSessionID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetUserRole also revokes the sessions of the user, their access tokens
+// carry the old role.
+func (s *PostgresStorage) SetUserRole(ctx context.Context, userID string, role string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE users SET role = $1 WHERE id = $2 AND role <> $1;", role, userID)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		var exists bool
+		if err := tx.GetContext(ctx, &exists, "SELECT EXISTS(SELECT 1 FROM users WHERE id = $1);", userID); err != nil {
+			return err
+		}
+
+		if !exists {
+			return ErrNoRows
+		}
+
+		return nil
+	}
+
+	if err := s.revokeUserSessions(ctx, tx, userID, ""); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *PostgresStorage) CreatePasswordResetToken(ctx context.Context, userID string, tokenHash string, ttl time.Duration) error {
+	_, err := s.db.ExecContext(
 		ctx,
-		`
-		CREATE TABLE IF NOT EXISTS orders(
-			id uuid DEFAULT gen_random_uuid() PRIMARY KEY,
-			number VARCHAR NOT NULL UNIQUE,
-			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			status VARCHAR NOT NULL,
-			user_id uuid NOT NULL,
-			accrual INT DEFAULT 0,
-			CONSTRAINT fk_user FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
-		);
-		`,
+		`INSERT INTO password_reset_tokens (token_hash, user_id, expires_at)
+		VALUES ($1, $2, LOCALTIMESTAMP + make_interval(secs => $3));`,
+		tokenHash, userID, ttl.Seconds(),
 	)
 
 	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == pgerrcode.ForeignKeyViolation {
+			return ErrNoRows
+		}
+
+		if errors.As(err, &pqErr) && pgerrcode.IsIntegrityConstraintViolation(string(pqErr.Code)) {
+			return ErrConflict
+		}
+
 		return err
 	}
 
-	_, err = tx.ExecContext(
+	return nil
+}
+
+func (s *PostgresStorage) ResetUserPassword(ctx context.Context, tokenHash string, passwordHash string) (entities.User, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return entities.User{}, err
+	}
+
+	defer tx.Rollback()
+
+	var userID string
+
+	// Marking the token used in the same statement that checks it lets only
+	// one of concurrent resets with the same token through.
+	err = tx.GetContext(
 		ctx,
-		`
-		CREATE TABLE IF NOT EXISTS orders_withdraw(
-			id uuid DEFAULT gen_random_uuid() PRIMARY KEY,
-			number VARCHAR NOT NULL UNIQUE,
-			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			user_id uuid NOT NULL,
-			withdrawn INT DEFAULT 0,
-			CONSTRAINT fk_user FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
-		);
-		`,
+		&userID,
+		`UPDATE password_reset_tokens SET used_at = LOCALTIMESTAMP
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > LOCALTIMESTAMP
+		RETURNING user_id;`,
+		tokenHash,
 	)
 
 	if err != nil {
-		return err
+		if errors.Is(err, sql.ErrNoRows) {
+			return entities.User{}, ErrNoRows
+		}
+
+		return entities.User{}, err
 	}
 
-	return tx.Commit()
+	if _, err := tx.ExecContext(
+		ctx,
+		"UPDATE password_reset_tokens SET used_at = LOCALTIMESTAMP WHERE user_id = $1 AND used_at IS NULL;",
+		userID,
+	); err != nil {
+		return entities.User{}, err
+	}
+
+	var user entities.User
+
+	if err := tx.GetContext(
+		ctx,
+		&user,
+		"UPDATE users SET password = $1 WHERE id = $2 RETURNING id, login, password, role;",
+		passwordHash, userID,
+	); err != nil {
+		return entities.User{}, err
+	}
+
+	if err := s.revokeUserSessions(ctx, tx, userID, ""); err != nil {
+		return entities.User{}, err
+	}
+
+	return user, tx.Commit()
+}
+
+// revokeUserSessions revokes all active sessions of the user except
+// keepSessionID, which may be empty.
+func (s *PostgresStorage) revokeUserSessions(ctx context.Context, e sqlx.ExecerContext, userID string, keepSessionID string) error {
+	_, err := e.ExecContext(
+		ctx,
+		`UPDATE sessions SET revoked_at = LOCALTIMESTAMP
+		WHERE user_id = $1 AND id::text <> $2 AND revoked_at IS NULL;`,
+		userID, keepSessionID,
+	)
+
+	return err
+}
+
+func (s *PostgresStorage) CreateUser(ctx context.Context, login string, passwordHash string) (string, error) {
+	var userID string
+
+	row := s.db.QueryRowxContext(
+		ctx,
+		`INSERT INTO users (login, password)
+		VALUES ($1, $2) RETURNING id;`,
+		login, passwordHash,
+	)
+
+	if err := row.Err(); err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pgerrcode.IsIntegrityConstraintViolation(string(pqErr.Code)) {
+			return "", ErrConflict
+
+		}
+
+		return "", err
+	}
+
+	if err := row.Scan(&userID); err != nil {
+		return "", err
+	}
+
+	return userID, nil
 }
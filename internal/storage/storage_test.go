@@ -0,0 +1,17 @@
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/VladKvetkin/gophermart/internal/storage/storagetest"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	storagetest.Run(t, storagetest.Memory)
+}
+
+// TestPostgresStorage skips every case unless GOPHERMART_TEST_DATABASE_URI
+// points at a Postgres instance.
+func TestPostgresStorage(t *testing.T) {
+	storagetest.Run(t, storagetest.Postgres)
+}
@@ -0,0 +1,1385 @@
+package storagetest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/VladKvetkin/gophermart/internal/entities"
+	"github.com/VladKvetkin/gophermart/internal/storage"
+	"github.com/jmoiron/sqlx"
+)
+
+// DatabaseURIEnv names the variable holding the Postgres instance the suite
+// runs against. Postgres runs are skipped when it is not set.
+const DatabaseURIEnv = "GOPHERMART_TEST_DATABASE_URI"
+
+type Factory func(t *testing.T) storage.Storage
+
+func Run(t *testing.T, newStorage Factory) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s storage.Storage)
+	}{
+		{"CreateUser", testCreateUser},
+		{"GetUserByLogin", testGetUserByLogin},
+		{"GetUserByID", testGetUserByID},
+		{"UpdateUserPassword", testUpdateUserPassword},
+		{"ChangeUserPassword", testChangeUserPassword},
+		{"SetUserRole", testSetUserRole},
+		{"ResetUserPassword", testResetUserPassword},
+		{"ResetUserPasswordConcurrent", testResetUserPasswordConcurrent},
+		{"PasswordResetTokenExpiry", testPasswordResetTokenExpiry},
+		{"CreateSession", testCreateSession},
+		{"RotateSession", testRotateSession},
+		{"RevokeSession", testRevokeSession},
+		{"SessionExpiry", testSessionExpiry},
+		{"RecordLoginAttempt", testRecordLoginAttempt},
+		{"RecordLoginAttemptConcurrent", testRecordLoginAttemptConcurrent},
+		{"ForgetLoginAttempt", testForgetLoginAttempt},
+		{"ForgetLoginAttemptLockout", testForgetLoginAttemptLockout},
+		{"ResetLoginFailures", testResetLoginFailures},
+		{"CreateOrder", testCreateOrder},
+		{"GetOrCreateOrderIfNotExists", testGetOrCreateOrderIfNotExists},
+		{"GetOrderByNumber", testGetOrderByNumber},
+		{"GetUserOrders", testGetUserOrders},
+		{"UpdateOrder", testUpdateOrder},
+		{"UpdateOrderForbiddenTransition", testUpdateOrderForbiddenTransition},
+		{"UpdateOrderCreditsOnce", testUpdateOrderCreditsOnce},
+		{"CreateWithdraw", testCreateWithdraw},
+		{"CreateWithdrawConcurrent", testCreateWithdrawConcurrent},
+		{"LargeAmounts", testLargeAmounts},
+		{"GetUserWithdrawals", testGetUserWithdrawals},
+		{"ClaimOrdersForAccrualer", testClaimOrdersForAccrualer},
+		{"ClaimOrderForAccrualer", testClaimOrderForAccrualer},
+		{"ReleaseOrders", testReleaseOrders},
+		{"RetryOrder", testRetryOrder},
+		{"PostponeOrder", testPostponeOrder},
+		{"InvalidateOrder", testInvalidateOrder},
+		{"AccrualBreakerState", testAccrualBreakerState},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newStorage(t))
+		})
+	}
+}
+
+func Memory(t *testing.T) storage.Storage {
+	return storage.NewMemoryStorage()
+}
+
+// Postgres creates every storage in its own schema, so tests never see each
+// other's rows, and drops the schema when the test ends.
+func Postgres(t *testing.T) storage.Storage {
+	s, err := storage.NewPostgresStorage(PostgresDB(t))
+	if err != nil {
+		t.Fatalf("create postgres storage: %v", err)
+	}
+
+	return s
+}
+
+// PostgresDB connects to an empty schema of its own, dropped when the test
+// ends, and skips the test unless DatabaseURIEnv is set.
+func PostgresDB(t *testing.T) *sqlx.DB {
+	databaseURI := os.Getenv(DatabaseURIEnv)
+	if databaseURI == "" {
+		t.Skipf("%s is not set", DatabaseURIEnv)
+	}
+
+	admin, err := sqlx.Connect("postgres", databaseURI)
+	if err != nil {
+		t.Fatalf("connect to %s: %v", DatabaseURIEnv, err)
+	}
+
+	t.Cleanup(func() { admin.Close() })
+
+	schema := "storagetest_" + randomHex(t, 8)
+	if _, err := admin.Exec("CREATE SCHEMA " + schema + ";"); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+
+	t.Cleanup(func() {
+		if _, err := admin.Exec("DROP SCHEMA " + schema + " CASCADE;"); err != nil {
+			t.Errorf("drop schema: %v", err)
+		}
+	})
+
+	schemaURI, err := url.Parse(databaseURI)
+	if err != nil {
+		t.Fatalf("parse %s: %v", DatabaseURIEnv, err)
+	}
+
+	query := schemaURI.Query()
+	query.Set("search_path", schema)
+	schemaURI.RawQuery = query.Encode()
+
+	db, err := sqlx.Connect("postgres", schemaURI.String())
+	if err != nil {
+		t.Fatalf("connect to schema: %v", err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+func testCreateUser(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	userID, err := s.CreateUser(ctx, "user", "hash")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	if userID == "" {
+		t.Fatal("CreateUser returned an empty id")
+	}
+
+	if _, err := s.CreateUser(ctx, "user", "other hash"); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("CreateUser with a taken login: got %v, want %v", err, storage.ErrConflict)
+	}
+}
+
+func testGetUserByLogin(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+
+	user, err := s.GetUserByLogin(ctx, "user")
+	if err != nil {
+		t.Fatalf("GetUserByLogin: %v", err)
+	}
+
+	if user.ID != userID || user.Login != "user" || user.PasswordHash != "hash" || user.Role != entities.RoleUser {
+		t.Fatalf("GetUserByLogin: got %+v, want user %q with its hash and role", user, userID)
+	}
+
+	if _, err := s.GetUserByLogin(ctx, "nobody"); !errors.Is(err, storage.ErrNoRows) {
+		t.Fatalf("GetUserByLogin with an unknown login: got %v, want %v", err, storage.ErrNoRows)
+	}
+}
+
+func testGetUserByID(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+
+	if user.ID != userID || user.Login != "user" || user.PasswordHash != "hash" || user.Role != entities.RoleUser {
+		t.Fatalf("GetUserByID: got %+v, want user %q with its hash and role", user, userID)
+	}
+
+	if _, err := s.GetUserByID(ctx, "00000000-0000-4000-8000-000000000000"); !errors.Is(err, storage.ErrNoRows) {
+		t.Fatalf("GetUserByID with an unknown id: got %v, want %v", err, storage.ErrNoRows)
+	}
+}
+
+func testUpdateUserPassword(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+	createUser(t, s, "other")
+
+	if err := s.UpdateUserPassword(ctx, userID, "new hash"); err != nil {
+		t.Fatalf("UpdateUserPassword: %v", err)
+	}
+
+	for login, want := range map[string]string{"user": "new hash", "other": "hash"} {
+		user, err := s.GetUserByLogin(ctx, login)
+		if err != nil {
+			t.Fatalf("GetUserByLogin: %v", err)
+		}
+
+		if user.PasswordHash != want {
+			t.Fatalf("password hash of %s: got %q, want %q", login, user.PasswordHash, want)
+		}
+	}
+
+	if err := s.UpdateUserPassword(ctx, "00000000-0000-4000-8000-000000000000", "hash"); !errors.Is(err, storage.ErrNoRows) {
+		t.Fatalf("UpdateUserPassword of an unknown user: got %v, want %v", err, storage.ErrNoRows)
+	}
+}
+
+func testChangeUserPassword(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+	otherID := createUser(t, s, "other")
+
+	current := createSession(t, s, userID, "current")
+	stale := createSession(t, s, userID, "stale")
+	foreign := createSession(t, s, otherID, "foreign")
+
+	if err := s.ChangeUserPassword(ctx, userID, "new hash", current.ID); err != nil {
+		t.Fatalf("ChangeUserPassword: %v", err)
+	}
+
+	if user, err := s.GetUserByID(ctx, userID); err != nil || user.PasswordHash != "new hash" {
+		t.Fatalf("password hash after ChangeUserPassword: got %+v, %v, want %q", user, err, "new hash")
+	}
+
+	for _, session := range []entities.Session{current, foreign} {
+		if _, err := s.GetActiveSession(ctx, session.ID); err != nil {
+			t.Fatalf("GetActiveSession of kept session %s: %v", session.RefreshTokenHash, err)
+		}
+	}
+
+	if _, err := s.GetActiveSession(ctx, stale.ID); !errors.Is(err, storage.ErrNoRows) {
+		t.Fatalf("GetActiveSession of another session of the user: got %v, want %v", err, storage.ErrNoRows)
+	}
+
+	if err := s.ChangeUserPassword(ctx, otherID, "new hash", ""); err != nil {
+		t.Fatalf("ChangeUserPassword without a kept session: %v", err)
+	}
+
+	if _, err := s.GetActiveSession(ctx, foreign.ID); !errors.Is(err, storage.ErrNoRows) {
+		t.Fatalf("GetActiveSession after ChangeUserPassword without a kept session: got %v, want %v", err, storage.ErrNoRows)
+	}
+
+	if err := s.ChangeUserPassword(ctx, "00000000-0000-4000-8000-000000000000", "hash", ""); !errors.Is(err, storage.ErrNoRows) {
+		t.Fatalf("ChangeUserPassword of an unknown user: got %v, want %v", err, storage.ErrNoRows)
+	}
+}
+
+func testSetUserRole(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+	otherID := createUser(t, s, "other")
+
+	session := createSession(t, s, userID, "refresh")
+	foreign := createSession(t, s, otherID, "foreign")
+
+	if err := s.SetUserRole(ctx, userID, entities.RoleAdmin); err != nil {
+		t.Fatalf("SetUserRole: %v", err)
+	}
+
+	if user, err := s.GetUserByLogin(ctx, "user"); err != nil || user.Role != entities.RoleAdmin {
+		t.Fatalf("role after SetUserRole: got %+v, %v, want %q", user, err, entities.RoleAdmin)
+	}
+
+	if _, err := s.GetActiveSession(ctx, session.ID); !errors.Is(err, storage.ErrNoRows) {
+		t.Fatalf("GetActiveSession after SetUserRole: got %v, want %v", err, storage.ErrNoRows)
+	}
+
+	if user, err := s.GetUserByID(ctx, otherID); err != nil || user.Role != entities.RoleUser {
+		t.Fatalf("role of another user: got %+v, %v, want %q", user, err, entities.RoleUser)
+	}
+
+	// Setting the role a user already has keeps their sessions.
+	if err := s.SetUserRole(ctx, otherID, entities.RoleUser); err != nil {
+		t.Fatalf("SetUserRole with the current role: %v", err)
+	}
+
+	if _, err := s.GetActiveSession(ctx, foreign.ID); err != nil {
+		t.Fatalf("GetActiveSession after SetUserRole with the current role: %v", err)
+	}
+
+	if err := s.SetUserRole(ctx, "00000000-0000-4000-8000-000000000000", entities.RoleAdmin); !errors.Is(err, storage.ErrNoRows) {
+		t.Fatalf("SetUserRole of an unknown user: got %v, want %v", err, storage.ErrNoRows)
+	}
+}
+
+func testResetUserPassword(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+	otherID := createUser(t, s, "other")
+
+	session := createSession(t, s, userID, "refresh")
+	foreign := createSession(t, s, otherID, "foreign")
+
+	for _, tokenHash := range []string{"first", "second"} {
+		if err := s.CreatePasswordResetToken(ctx, userID, tokenHash, time.Hour); err != nil {
+			t.Fatalf("CreatePasswordResetToken: %v", err)
+		}
+	}
+
+	if err := s.CreatePasswordResetToken(ctx, otherID, "foreign", time.Hour); err != nil {
+		t.Fatalf("CreatePasswordResetToken: %v", err)
+	}
+
+	if err := s.CreatePasswordResetToken(ctx, otherID, "first", time.Hour); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("CreatePasswordResetToken with a taken token: got %v, want %v", err, storage.ErrConflict)
+	}
+
+	if err := s.CreatePasswordResetToken(ctx, "00000000-0000-4000-8000-000000000000", "unknown", time.Hour); !errors.Is(err, storage.ErrNoRows) {
+		t.Fatalf("CreatePasswordResetToken of an unknown user: got %v, want %v", err, storage.ErrNoRows)
+	}
+
+	user, err := s.ResetUserPassword(ctx, "second", "new hash")
+	if err != nil {
+		t.Fatalf("ResetUserPassword: %v", err)
+	}
+
+	if user.ID != userID || user.Login != "user" || user.PasswordHash != "new hash" {
+		t.Fatalf("ResetUserPassword: got %+v, want user %q with the new hash", user, userID)
+	}
+
+	if _, err := s.GetActiveSession(ctx, session.ID); !errors.Is(err, storage.ErrNoRows) {
+		t.Fatalf("GetActiveSession after ResetUserPassword: got %v, want %v", err, storage.ErrNoRows)
+	}
+
+	for _, tokenHash := range []string{"second", "first"} {
+		if _, err := s.ResetUserPassword(ctx, tokenHash, "other hash"); !errors.Is(err, storage.ErrNoRows) {
+			t.Fatalf("ResetUserPassword with used token %s: got %v, want %v", tokenHash, err, storage.ErrNoRows)
+		}
+	}
+
+	if _, err := s.GetActiveSession(ctx, foreign.ID); err != nil {
+		t.Fatalf("GetActiveSession of another user: %v", err)
+	}
+
+	if _, err := s.ResetUserPassword(ctx, "foreign", "other hash"); err != nil {
+		t.Fatalf("ResetUserPassword of another user: %v", err)
+	}
+
+	if _, err := s.ResetUserPassword(ctx, "unknown", "other hash"); !errors.Is(err, storage.ErrNoRows) {
+		t.Fatalf("ResetUserPassword with an unknown token: got %v, want %v", err, storage.ErrNoRows)
+	}
+}
+
+func testResetUserPasswordConcurrent(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+
+	if err := s.CreatePasswordResetToken(ctx, userID, "token", time.Hour); err != nil {
+		t.Fatalf("CreatePasswordResetToken: %v", err)
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		resets int
+	)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func(passwordHash string) {
+			defer wg.Done()
+
+			if _, err := s.ResetUserPassword(ctx, "token", passwordHash); err == nil {
+				mu.Lock()
+				resets++
+				mu.Unlock()
+			}
+		}(fmt.Sprintf("hash%d", i))
+	}
+
+	wg.Wait()
+
+	if resets != 1 {
+		t.Fatalf("concurrent ResetUserPassword with the same token: %d succeeded, want 1", resets)
+	}
+}
+
+func testPasswordResetTokenExpiry(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+
+	if err := s.CreatePasswordResetToken(ctx, userID, "token", 10*time.Millisecond); err != nil {
+		t.Fatalf("CreatePasswordResetToken: %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if _, err := s.ResetUserPassword(ctx, "token", "new hash"); !errors.Is(err, storage.ErrNoRows) {
+		t.Fatalf("ResetUserPassword with an expired token: got %v, want %v", err, storage.ErrNoRows)
+	}
+
+	if user, err := s.GetUserByID(ctx, userID); err != nil || user.PasswordHash != "hash" {
+		t.Fatalf("password hash after an expired reset: got %+v, %v, want %q", user, err, "hash")
+	}
+}
+
+func testCreateSession(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+
+	session, err := s.CreateSession(ctx, userID, "refresh", time.Hour)
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	if session.ID == "" || session.UserID != userID || session.RefreshTokenHash != "refresh" || session.RevokedAt.Valid {
+		t.Fatalf("CreateSession: got %+v, want an active session of %q", session, userID)
+	}
+
+	got, err := s.GetActiveSession(ctx, session.ID)
+	if err != nil {
+		t.Fatalf("GetActiveSession: %v", err)
+	}
+
+	if got.ID != session.ID || got.UserID != userID {
+		t.Fatalf("GetActiveSession: got %+v, want %+v", got, session)
+	}
+
+	if _, err := s.CreateSession(ctx, userID, "refresh", time.Hour); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("CreateSession with a taken refresh token: got %v, want %v", err, storage.ErrConflict)
+	}
+
+	if _, err := s.CreateSession(ctx, "00000000-0000-4000-8000-000000000000", "other", time.Hour); !errors.Is(err, storage.ErrNoRows) {
+		t.Fatalf("CreateSession of an unknown user: got %v, want %v", err, storage.ErrNoRows)
+	}
+
+	if _, err := s.GetActiveSession(ctx, "00000000-0000-4000-8000-000000000000"); !errors.Is(err, storage.ErrNoRows) {
+		t.Fatalf("GetActiveSession of an unknown session: got %v, want %v", err, storage.ErrNoRows)
+	}
+}
+
+func testRotateSession(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+
+	session, err := s.CreateSession(ctx, userID, "first", time.Hour)
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	rotated, err := s.RotateSession(ctx, "first", "second", time.Hour)
+	if err != nil {
+		t.Fatalf("RotateSession: %v", err)
+	}
+
+	if rotated.ID != session.ID || rotated.RefreshTokenHash != "second" {
+		t.Fatalf("RotateSession: got %+v, want session %q with the new refresh token", rotated, session.ID)
+	}
+
+	if _, err := s.RotateSession(ctx, "first", "third", time.Hour); !errors.Is(err, storage.ErrNoRows) {
+		t.Fatalf("RotateSession with a rotated refresh token: got %v, want %v", err, storage.ErrNoRows)
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		rotates int
+	)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func(newRefreshToken string) {
+			defer wg.Done()
+
+			if _, err := s.RotateSession(ctx, "second", newRefreshToken, time.Hour); err == nil {
+				mu.Lock()
+				rotates++
+				mu.Unlock()
+			}
+		}(fmt.Sprintf("concurrent%d", i))
+	}
+
+	wg.Wait()
+
+	if rotates != 1 {
+		t.Fatalf("concurrent RotateSession with the same refresh token: %d succeeded, want 1", rotates)
+	}
+}
+
+func testRevokeSession(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+
+	session, err := s.CreateSession(ctx, userID, "refresh", time.Hour)
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	other, err := s.CreateSession(ctx, userID, "other", time.Hour)
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := s.RevokeSession(ctx, session.ID); err != nil {
+			t.Fatalf("RevokeSession: %v", err)
+		}
+	}
+
+	if _, err := s.GetActiveSession(ctx, session.ID); !errors.Is(err, storage.ErrNoRows) {
+		t.Fatalf("GetActiveSession of a revoked session: got %v, want %v", err, storage.ErrNoRows)
+	}
+
+	if _, err := s.RotateSession(ctx, "refresh", "new", time.Hour); !errors.Is(err, storage.ErrNoRows) {
+		t.Fatalf("RotateSession of a revoked session: got %v, want %v", err, storage.ErrNoRows)
+	}
+
+	if _, err := s.GetActiveSession(ctx, other.ID); err != nil {
+		t.Fatalf("GetActiveSession of another session: %v", err)
+	}
+}
+
+func testSessionExpiry(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+
+	session, err := s.CreateSession(ctx, userID, "refresh", 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if _, err := s.GetActiveSession(ctx, session.ID); !errors.Is(err, storage.ErrNoRows) {
+		t.Fatalf("GetActiveSession of an expired session: got %v, want %v", err, storage.ErrNoRows)
+	}
+
+	if _, err := s.RotateSession(ctx, "refresh", "new", time.Hour); !errors.Is(err, storage.ErrNoRows) {
+		t.Fatalf("RotateSession of an expired session: got %v, want %v", err, storage.ErrNoRows)
+	}
+}
+
+func testRecordLoginAttempt(t *testing.T, s storage.Storage) {
+	lockAt := func(limit int, lockout time.Duration) func(int) time.Duration {
+		return func(attempts int) time.Duration {
+			if attempts < limit {
+				return 0
+			}
+
+			return lockout
+		}
+	}
+
+	for want := 1; want <= 3; want++ {
+		attempts, lockout := recordLoginAttempt(t, s, "login:user", time.Hour, lockAt(3, time.Minute))
+		if attempts != want || lockout != 0 {
+			t.Fatalf("RecordLoginAttempt: got attempt %d locked for %v, want attempt %d", attempts, lockout, want)
+		}
+	}
+
+	// A refused attempt is not counted.
+	for i := 0; i < 2; i++ {
+		attempts, lockout := recordLoginAttempt(t, s, "login:user", time.Hour, lockAt(3, time.Minute))
+		if attempts != 0 || lockout <= 50*time.Second || lockout > time.Minute {
+			t.Fatalf("RecordLoginAttempt of a locked key: got attempt %d locked for %v, want about %v", attempts, lockout, time.Minute)
+		}
+	}
+
+	if attempts, lockout := recordLoginAttempt(t, s, "login:other", time.Hour, lockAt(3, time.Minute)); attempts != 1 || lockout != 0 {
+		t.Fatalf("RecordLoginAttempt of another key: got attempt %d locked for %v, want attempt 1", attempts, lockout)
+	}
+
+	recordLoginAttempt(t, s, "login:expired", time.Hour, lockAt(1, 10*time.Millisecond))
+	recordLoginAttempt(t, s, "login:window", 10*time.Millisecond, lockAt(3, time.Minute))
+
+	time.Sleep(50 * time.Millisecond)
+
+	if attempts, lockout := recordLoginAttempt(t, s, "login:expired", time.Hour, lockAt(1, 10*time.Millisecond)); attempts != 2 || lockout != 0 {
+		t.Fatalf("RecordLoginAttempt after the lockout: got attempt %d locked for %v, want attempt 2", attempts, lockout)
+	}
+
+	if attempts, lockout := recordLoginAttempt(t, s, "login:window", 10*time.Millisecond, lockAt(3, time.Minute)); attempts != 1 || lockout != 0 {
+		t.Fatalf("RecordLoginAttempt after the window: got attempt %d locked for %v, want attempt 1", attempts, lockout)
+	}
+}
+
+// testRecordLoginAttemptConcurrent makes many parallel attempts of a key
+// that is locked from its third attempt: only three of them may pass.
+func testRecordLoginAttemptConcurrent(t *testing.T, s storage.Storage) {
+	const (
+		limit    = 3
+		attempts = 20
+	)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			lockout, err := s.RecordLoginAttempt(context.Background(), "login:user", time.Hour, func(attempts int) time.Duration {
+				if attempts < limit {
+					return 0
+				}
+
+				return time.Hour
+			})
+			if err != nil {
+				t.Errorf("RecordLoginAttempt: %v", err)
+				return
+			}
+
+			if lockout == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if allowed != limit {
+		t.Fatalf("parallel RecordLoginAttempt: got %d allowed, want %d", allowed, limit)
+	}
+}
+
+func testForgetLoginAttempt(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	if err := s.ForgetLoginAttempt(ctx, "ip:127.0.0.1"); err != nil {
+		t.Fatalf("ForgetLoginAttempt of an unknown key: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		recordLoginAttempt(t, s, "ip:127.0.0.1", time.Hour, noLockout)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := s.ForgetLoginAttempt(ctx, "ip:127.0.0.1"); err != nil {
+			t.Fatalf("ForgetLoginAttempt: %v", err)
+		}
+	}
+
+	if attempts, _ := recordLoginAttempt(t, s, "ip:127.0.0.1", time.Hour, noLockout); attempts != 1 {
+		t.Fatalf("RecordLoginAttempt after forgetting every attempt: got attempt %d, want 1", attempts)
+	}
+}
+
+// testForgetLoginAttemptLockout forgets an attempt that locked its key: the
+// lockout goes with it.
+func testForgetLoginAttemptLockout(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	recordLoginAttempt(t, s, "ip:127.0.0.1", time.Hour, noLockout)
+	recordLoginAttempt(t, s, "ip:127.0.0.1", time.Hour, func(int) time.Duration { return time.Hour })
+
+	if err := s.ForgetLoginAttempt(ctx, "ip:127.0.0.1"); err != nil {
+		t.Fatalf("ForgetLoginAttempt: %v", err)
+	}
+
+	if attempts, lockout := recordLoginAttempt(t, s, "ip:127.0.0.1", time.Hour, noLockout); attempts != 2 || lockout != 0 {
+		t.Fatalf("RecordLoginAttempt after forgetting the locking attempt: got attempt %d locked for %v, want attempt 2", attempts, lockout)
+	}
+}
+
+func testResetLoginFailures(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		recordLoginAttempt(t, s, "login:user", time.Hour, func(int) time.Duration { return time.Hour })
+
+		if err := s.ResetLoginFailures(ctx, "login:user"); err != nil {
+			t.Fatalf("ResetLoginFailures: %v", err)
+		}
+	}
+
+	if err := s.ResetLoginFailures(ctx, "login:user"); err != nil {
+		t.Fatalf("ResetLoginFailures of an unknown key: %v", err)
+	}
+
+	if attempts, lockout := recordLoginAttempt(t, s, "login:user", time.Hour, noLockout); attempts != 1 || lockout != 0 {
+		t.Fatalf("RecordLoginAttempt after reset: got attempt %d locked for %v, want attempt 1", attempts, lockout)
+	}
+}
+
+func testCreateOrder(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+	otherID := createUser(t, s, "other")
+
+	if _, err := s.CreateOrder(ctx, userID, "12345678903"); err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+
+	if _, err := s.CreateOrder(ctx, userID, "12345678903"); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("CreateOrder with the same user: got %v, want %v", err, storage.ErrConflict)
+	}
+
+	if _, err := s.CreateOrder(ctx, otherID, "12345678903"); !errors.Is(err, storage.ErrConflict) {
+		t.Fatalf("CreateOrder with another user: got %v, want %v", err, storage.ErrConflict)
+	}
+}
+
+func testGetOrCreateOrderIfNotExists(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+	otherID := createUser(t, s, "other")
+
+	order, created, err := s.GetOrCreateOrderIfNotExists(ctx, userID, "12345678903")
+	if err != nil {
+		t.Fatalf("GetOrCreateOrderIfNotExists: %v", err)
+	}
+
+	if !created || order.UserID != userID || order.Status != entities.OrderStatusNew {
+		t.Fatalf("GetOrCreateOrderIfNotExists: got %+v created=%v, want a NEW order of %q", order, created, userID)
+	}
+
+	for _, uploader := range []string{userID, otherID} {
+		order, created, err := s.GetOrCreateOrderIfNotExists(ctx, uploader, "12345678903")
+		if err != nil {
+			t.Fatalf("GetOrCreateOrderIfNotExists again: %v", err)
+		}
+
+		if created {
+			t.Fatal("GetOrCreateOrderIfNotExists created an existing order")
+		}
+
+		if order.UserID != userID {
+			t.Fatalf("GetOrCreateOrderIfNotExists: owner %q, want %q", order.UserID, userID)
+		}
+	}
+}
+
+func testGetOrderByNumber(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+	created := createOrder(t, s, userID, "12345678903")
+
+	order, err := s.GetOrderByNumber(ctx, "12345678903")
+	if err != nil {
+		t.Fatalf("GetOrderByNumber: %v", err)
+	}
+
+	if order.ID != created.ID || order.UserID != userID {
+		t.Fatalf("GetOrderByNumber: got %+v, want %+v", order, created)
+	}
+
+	if _, err := s.GetOrderByNumber(ctx, "9278923470"); !errors.Is(err, storage.ErrNoRows) {
+		t.Fatalf("GetOrderByNumber of an unknown order: got %v, want %v", err, storage.ErrNoRows)
+	}
+}
+
+func testGetUserOrders(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+	otherID := createUser(t, s, "other")
+
+	numbers := []string{"12345678903", "9278923470", "346436439"}
+	for _, number := range numbers {
+		createOrder(t, s, userID, number)
+	}
+
+	createOrder(t, s, otherID, "2377225624")
+
+	orders, err := s.GetUserOrders(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserOrders: %v", err)
+	}
+
+	if len(orders) != len(numbers) {
+		t.Fatalf("GetUserOrders: got %d orders, want %d", len(orders), len(numbers))
+	}
+
+	for i, order := range orders {
+		if order.Number != numbers[i] {
+			t.Fatalf("GetUserOrders[%d]: got %q, want %q", i, order.Number, numbers[i])
+		}
+	}
+}
+
+func testUpdateOrder(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+	order := createOrder(t, s, userID, "12345678903")
+
+	if err := s.UpdateOrder(ctx, order, 50050, entities.OrderStatusProcessed); err != nil {
+		t.Fatalf("UpdateOrder: %v", err)
+	}
+
+	orders, err := s.GetUserOrders(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserOrders: %v", err)
+	}
+
+	if len(orders) != 1 || orders[0].Status != entities.OrderStatusProcessed || orders[0].Accrual != 50050 {
+		t.Fatalf("GetUserOrders after UpdateOrder: got %+v", orders)
+	}
+
+	assertBalance(t, s, userID, 50050, 0)
+
+	ledger, err := s.GetUserLedger(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserLedger: %v", err)
+	}
+
+	if len(ledger) != 1 || ledger[0].Kind != entities.LedgerEntryKindAccrual || ledger[0].Amount != 50050 {
+		t.Fatalf("GetUserLedger: got %+v, want one accrual of 50050", ledger)
+	}
+}
+
+func testUpdateOrderForbiddenTransition(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+	credit(t, s, userID, "12345678903", 500)
+
+	orders, err := s.GetUserOrders(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserOrders: %v", err)
+	}
+
+	for _, status := range []string{entities.OrderStatusNew, entities.OrderStatusProcessing, entities.OrderStatusInvalid} {
+		err := s.UpdateOrder(ctx, orders[0], 0, status)
+
+		var transitionErr *entities.TransitionError
+		if !errors.As(err, &transitionErr) || transitionErr.From != entities.OrderStatusProcessed || transitionErr.To != status {
+			t.Fatalf("UpdateOrder of a PROCESSED order to %s: got %v, want a transition error", status, err)
+		}
+	}
+
+	if err := s.InvalidateOrder(ctx, orders[0], "gave up"); !errors.Is(err, entities.ErrForbiddenTransition) {
+		t.Fatalf("InvalidateOrder of a PROCESSED order: got %v, want %v", err, entities.ErrForbiddenTransition)
+	}
+
+	orders, err = s.GetUserOrders(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserOrders: %v", err)
+	}
+
+	if orders[0].Status != entities.OrderStatusProcessed || orders[0].Accrual != 500 {
+		t.Fatalf("GetUserOrders after forbidden transitions: got %+v", orders[0])
+	}
+
+	assertBalance(t, s, userID, 500, 0)
+}
+
+func testUpdateOrderCreditsOnce(t *testing.T, s storage.Storage) {
+	const deliveries = 20
+
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+	order := createOrder(t, s, userID, "12345678903")
+
+	if err := s.UpdateOrder(ctx, order, 0, entities.OrderStatusProcessing); err != nil {
+		t.Fatalf("UpdateOrder: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < deliveries; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := s.UpdateOrder(ctx, order, 500, entities.OrderStatusProcessed); err != nil {
+				t.Errorf("UpdateOrder: %v", err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if err := s.UpdateOrder(ctx, order, 500, entities.OrderStatusProcessed); err != nil {
+		t.Fatalf("UpdateOrder of an already PROCESSED order: %v", err)
+	}
+
+	assertBalance(t, s, userID, 500, 0)
+
+	ledger, err := s.GetUserLedger(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserLedger: %v", err)
+	}
+
+	if len(ledger) != 1 {
+		t.Fatalf("GetUserLedger: got %d entries, want 1", len(ledger))
+	}
+}
+
+func testCreateWithdraw(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+
+	if _, err := s.CreateWithdraw(ctx, userID, "2377225624", 1); !errors.Is(err, storage.ErrNotEnoughAccrual) {
+		t.Fatalf("CreateWithdraw on an empty balance: got %v, want %v", err, storage.ErrNotEnoughAccrual)
+	}
+
+	credit(t, s, userID, "12345678903", 500)
+
+	if _, err := s.CreateWithdraw(ctx, userID, "2377225624", 200); err != nil {
+		t.Fatalf("CreateWithdraw: %v", err)
+	}
+
+	assertBalance(t, s, userID, 300, 200)
+
+	if _, err := s.CreateWithdraw(ctx, userID, "79927398713", 301); !errors.Is(err, storage.ErrNotEnoughAccrual) {
+		t.Fatalf("CreateWithdraw over the balance: got %v, want %v", err, storage.ErrNotEnoughAccrual)
+	}
+
+	if _, err := s.CreateWithdraw(ctx, userID, "79927398713", 300); err != nil {
+		t.Fatalf("CreateWithdraw of the whole balance: %v", err)
+	}
+
+	assertBalance(t, s, userID, 0, 500)
+}
+
+// testLargeAmounts books sums in cents beyond the 32-bit range.
+func testLargeAmounts(t *testing.T, s storage.Storage) {
+	const (
+		accrual   = 5_000_000_000
+		withdrawn = 3_000_000_000
+	)
+
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+	credit(t, s, userID, "12345678903", accrual)
+
+	if _, err := s.CreateWithdraw(ctx, userID, "2377225624", accrual+1); !errors.Is(err, storage.ErrNotEnoughAccrual) {
+		t.Fatalf("CreateWithdraw over the balance: got %v, want %v", err, storage.ErrNotEnoughAccrual)
+	}
+
+	if _, err := s.CreateWithdraw(ctx, userID, "2377225624", withdrawn); err != nil {
+		t.Fatalf("CreateWithdraw: %v", err)
+	}
+
+	assertBalance(t, s, userID, accrual-withdrawn, withdrawn)
+}
+
+func testCreateWithdrawConcurrent(t *testing.T, s storage.Storage) {
+	const (
+		balance   = 1000
+		amount    = 100
+		attempts  = 50
+		available = balance / amount
+	)
+
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+	credit(t, s, userID, "12345678903", balance)
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		succeeded int
+	)
+
+	for i := 0; i < attempts; i++ {
+		number := randomHex(t, 8)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := s.CreateWithdraw(ctx, userID, number, amount)
+			if err != nil && !errors.Is(err, storage.ErrNotEnoughAccrual) {
+				t.Errorf("CreateWithdraw: %v", err)
+				return
+			}
+
+			if err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if succeeded != available {
+		t.Fatalf("concurrent CreateWithdraw: %d succeeded, want %d", succeeded, available)
+	}
+
+	assertBalance(t, s, userID, 0, balance)
+}
+
+func testGetUserWithdrawals(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+	credit(t, s, userID, "12345678903", 1000)
+
+	numbers := []string{"2377225624", "79927398713", "4561261212345467"}
+	for _, number := range numbers {
+		if _, err := s.CreateWithdraw(ctx, userID, number, 100); err != nil {
+			t.Fatalf("CreateWithdraw: %v", err)
+		}
+
+		// created_at has microsecond precision in Postgres.
+		time.Sleep(time.Millisecond)
+	}
+
+	withdrawals, err := s.GetUserWithdrawals(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserWithdrawals: %v", err)
+	}
+
+	if len(withdrawals) != len(numbers) {
+		t.Fatalf("GetUserWithdrawals: got %d withdrawals, want %d", len(withdrawals), len(numbers))
+	}
+
+	for i, withdrawal := range withdrawals {
+		if withdrawal.Number != numbers[i] || withdrawal.Withdrawn != 100 {
+			t.Fatalf("GetUserWithdrawals[%d]: got %+v, want %q of 100", i, withdrawal, numbers[i])
+		}
+
+		if i > 0 && withdrawal.CreatedAt.Before(withdrawals[i-1].CreatedAt) {
+			t.Fatalf("GetUserWithdrawals is not ordered by created_at: %+v", withdrawals)
+		}
+	}
+}
+
+func testClaimOrdersForAccrualer(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+
+	statuses := map[string]string{
+		"12345678903":      entities.OrderStatusNew,
+		"9278923470":       entities.OrderStatusProcessing,
+		"346436439":        entities.OrderStatusInvalid,
+		"2377225624":       entities.OrderStatusProcessed,
+		"4561261212345467": entities.OrderStatusNew,
+	}
+
+	for number, status := range statuses {
+		order := createOrder(t, s, userID, number)
+		if status == entities.OrderStatusNew {
+			continue
+		}
+
+		if err := s.UpdateOrder(ctx, order, 0, status); err != nil {
+			t.Fatalf("UpdateOrder: %v", err)
+		}
+	}
+
+	first, err := s.ClaimOrdersForAccrualer(ctx, 2, time.Hour)
+	if err != nil {
+		t.Fatalf("ClaimOrdersForAccrualer: %v", err)
+	}
+
+	second, err := s.ClaimOrdersForAccrualer(ctx, 100, time.Hour)
+	if err != nil {
+		t.Fatalf("ClaimOrdersForAccrualer: %v", err)
+	}
+
+	if len(first) != 2 || len(second) != 1 {
+		t.Fatalf("ClaimOrdersForAccrualer: got batches of %d and %d, want 2 and 1", len(first), len(second))
+	}
+
+	for _, order := range append(first, second...) {
+		if order.Status == entities.OrderStatusProcessed || order.Status == entities.OrderStatusInvalid {
+			t.Fatalf("ClaimOrdersForAccrualer returned a final order: %+v", order)
+		}
+	}
+
+	if claimed, err := s.ClaimOrdersForAccrualer(ctx, 100, time.Hour); err != nil || len(claimed) != 0 {
+		t.Fatalf("ClaimOrdersForAccrualer with every order leased: got %d orders, err %v", len(claimed), err)
+	}
+
+	if err := s.UpdateOrder(ctx, second[0], 0, entities.OrderStatusProcessing); err != nil {
+		t.Fatalf("UpdateOrder: %v", err)
+	}
+
+	released, err := s.ClaimOrdersForAccrualer(ctx, 100, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("ClaimOrdersForAccrualer after UpdateOrder: %v", err)
+	}
+
+	if len(released) != 1 || released[0].ID != second[0].ID {
+		t.Fatalf("ClaimOrdersForAccrualer after UpdateOrder: got %+v, want %s", released, second[0].Number)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	expired, err := s.ClaimOrdersForAccrualer(ctx, 100, time.Hour)
+	if err != nil {
+		t.Fatalf("ClaimOrdersForAccrualer after the lease expired: %v", err)
+	}
+
+	if len(expired) != 1 || expired[0].ID != second[0].ID {
+		t.Fatalf("ClaimOrdersForAccrualer after the lease expired: got %+v, want %s", expired, second[0].Number)
+	}
+}
+
+func testClaimOrderForAccrualer(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+	created := createOrder(t, s, userID, "12345678903")
+
+	order, ok, err := s.ClaimOrderForAccrualer(ctx, "12345678903", time.Hour)
+	if err != nil || !ok {
+		t.Fatalf("ClaimOrderForAccrualer: got %v, %v, want a claimed order", ok, err)
+	}
+
+	if order.ID != created.ID || !order.LeaseExpiresAt.Valid {
+		t.Fatalf("ClaimOrderForAccrualer: got %+v, want a leased order %s", order, created.ID)
+	}
+
+	if _, ok, err := s.ClaimOrderForAccrualer(ctx, "12345678903", time.Hour); err != nil || ok {
+		t.Fatalf("ClaimOrderForAccrualer of a leased order: got %v, %v, want nothing claimed", ok, err)
+	}
+
+	if claimed, err := s.ClaimOrdersForAccrualer(ctx, 100, time.Hour); err != nil || len(claimed) != 0 {
+		t.Fatalf("ClaimOrdersForAccrualer after ClaimOrderForAccrualer: got %d orders, err %v", len(claimed), err)
+	}
+
+	if err := s.UpdateOrder(ctx, order, 100, entities.OrderStatusProcessed); err != nil {
+		t.Fatalf("UpdateOrder: %v", err)
+	}
+
+	if _, ok, err := s.ClaimOrderForAccrualer(ctx, "12345678903", time.Hour); err != nil || ok {
+		t.Fatalf("ClaimOrderForAccrualer of a final order: got %v, %v, want nothing claimed", ok, err)
+	}
+
+	if _, ok, err := s.ClaimOrderForAccrualer(ctx, "9278923470", time.Hour); err != nil || ok {
+		t.Fatalf("ClaimOrderForAccrualer of an unknown order: got %v, %v, want nothing claimed", ok, err)
+	}
+}
+
+func testReleaseOrders(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+	createOrder(t, s, userID, "12345678903")
+	createOrder(t, s, userID, "79927398713")
+
+	claimed, err := s.ClaimOrdersForAccrualer(ctx, 100, time.Hour)
+	if err != nil || len(claimed) != 2 {
+		t.Fatalf("ClaimOrdersForAccrualer: got %d orders, err %v, want 2", len(claimed), err)
+	}
+
+	if err := s.ReleaseOrders(ctx, claimed[:1]); err != nil {
+		t.Fatalf("ReleaseOrders: %v", err)
+	}
+
+	again := claimOne(t, s, time.Hour)
+	if again.ID != claimed[0].ID {
+		t.Fatalf("ClaimOrdersForAccrualer after ReleaseOrders: got %s, want %s", again.ID, claimed[0].ID)
+	}
+
+	// The first lease is gone, releasing it must not drop the new one.
+	if err := s.ReleaseOrders(ctx, claimed[:1]); err != nil {
+		t.Fatalf("ReleaseOrders of a lease taken over: %v", err)
+	}
+
+	if orders, err := s.ClaimOrdersForAccrualer(ctx, 100, time.Hour); err != nil || len(orders) != 0 {
+		t.Fatalf("ClaimOrdersForAccrualer after releasing a lease taken over: got %d orders, err %v", len(orders), err)
+	}
+}
+
+func testRetryOrder(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+	createOrder(t, s, userID, "12345678903")
+
+	claimed := claimOne(t, s, time.Hour)
+
+	if err := s.RetryOrder(ctx, claimed, "not registered", time.Hour); err != nil {
+		t.Fatalf("RetryOrder: %v", err)
+	}
+
+	if orders, err := s.ClaimOrdersForAccrualer(ctx, 100, time.Hour); err != nil || len(orders) != 0 {
+		t.Fatalf("ClaimOrdersForAccrualer before the retry is due: got %d orders, err %v", len(orders), err)
+	}
+
+	orders, err := s.GetUserOrders(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserOrders: %v", err)
+	}
+
+	if orders[0].Attempts != 1 || orders[0].LastError.String != "not registered" {
+		t.Fatalf("GetUserOrders after RetryOrder: got %+v", orders[0])
+	}
+
+	if err := s.RetryOrder(ctx, orders[0], "still not registered", 0); err != nil {
+		t.Fatalf("RetryOrder: %v", err)
+	}
+
+	due := claimOne(t, s, time.Hour)
+	if due.Attempts != 2 {
+		t.Fatalf("ClaimOrdersForAccrualer once the retry is due: got %d attempts, want 2", due.Attempts)
+	}
+
+	if err := s.UpdateOrder(ctx, due, 0, entities.OrderStatusProcessing); err != nil {
+		t.Fatalf("UpdateOrder: %v", err)
+	}
+
+	if updated := claimOne(t, s, time.Hour); updated.Attempts != 0 || updated.LastError.Valid {
+		t.Fatalf("UpdateOrder did not reset the attempts: got %+v", updated)
+	}
+}
+
+func testPostponeOrder(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+	createOrder(t, s, userID, "12345678903")
+
+	if err := s.PostponeOrder(ctx, claimOne(t, s, time.Hour), "unavailable", time.Hour); err != nil {
+		t.Fatalf("PostponeOrder: %v", err)
+	}
+
+	if orders, err := s.ClaimOrdersForAccrualer(ctx, 100, time.Hour); err != nil || len(orders) != 0 {
+		t.Fatalf("ClaimOrdersForAccrualer before the check is due: got %d orders, err %v", len(orders), err)
+	}
+
+	orders, err := s.GetUserOrders(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserOrders: %v", err)
+	}
+
+	if err := s.PostponeOrder(ctx, orders[0], "still unavailable", 0); err != nil {
+		t.Fatalf("PostponeOrder: %v", err)
+	}
+
+	if due := claimOne(t, s, time.Hour); due.Attempts != 0 || due.LastError.String != "still unavailable" {
+		t.Fatalf("ClaimOrdersForAccrualer once the check is due: got %+v, want no attempts and the last error", due)
+	}
+}
+
+func testInvalidateOrder(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	userID := createUser(t, s, "user")
+	createOrder(t, s, userID, "12345678903")
+
+	if err := s.InvalidateOrder(ctx, claimOne(t, s, time.Hour), "gave up"); err != nil {
+		t.Fatalf("InvalidateOrder: %v", err)
+	}
+
+	orders, err := s.GetUserOrders(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserOrders: %v", err)
+	}
+
+	if orders[0].Status != entities.OrderStatusInvalid || orders[0].LastError.String != "gave up" {
+		t.Fatalf("GetUserOrders after InvalidateOrder: got %+v", orders[0])
+	}
+
+	if claimed, err := s.ClaimOrdersForAccrualer(ctx, 100, time.Hour); err != nil || len(claimed) != 0 {
+		t.Fatalf("ClaimOrdersForAccrualer returned an invalidated order: got %d orders, err %v", len(claimed), err)
+	}
+}
+
+func testAccrualBreakerState(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	if _, err := s.GetAccrualBreakerState(ctx, time.Hour); !errors.Is(err, storage.ErrNoRows) {
+		t.Fatalf("GetAccrualBreakerState before a save: got %v, want %v", err, storage.ErrNoRows)
+	}
+
+	for _, state := range []string{"closed", "open"} {
+		if err := s.SaveAccrualBreakerState(ctx, state); err != nil {
+			t.Fatalf("SaveAccrualBreakerState: %v", err)
+		}
+
+		if got, err := s.GetAccrualBreakerState(ctx, time.Hour); err != nil || got != state {
+			t.Fatalf("GetAccrualBreakerState: got %q, %v, want %q", got, err, state)
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if _, err := s.GetAccrualBreakerState(ctx, 10*time.Millisecond); !errors.Is(err, storage.ErrNoRows) {
+		t.Fatalf("GetAccrualBreakerState of a stale state: got %v, want %v", err, storage.ErrNoRows)
+	}
+}
+
+func claimOne(t *testing.T, s storage.Storage, lease time.Duration) entities.Order {
+	t.Helper()
+
+	orders, err := s.ClaimOrdersForAccrualer(context.Background(), 1, lease)
+	if err != nil {
+		t.Fatalf("ClaimOrdersForAccrualer: %v", err)
+	}
+
+	if len(orders) != 1 {
+		t.Fatalf("ClaimOrdersForAccrualer: got %d orders, want 1", len(orders))
+	}
+
+	return orders[0]
+}
+
+func createUser(t *testing.T, s storage.Storage, login string) string {
+	userID, err := s.CreateUser(context.Background(), login, "hash")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	return userID
+}
+
+func createSession(t *testing.T, s storage.Storage, userID string, refreshTokenHash string) entities.Session {
+	t.Helper()
+
+	session, err := s.CreateSession(context.Background(), userID, refreshTokenHash, time.Hour)
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	return session
+}
+
+func createOrder(t *testing.T, s storage.Storage, userID string, number string) entities.Order {
+	order, _, err := s.GetOrCreateOrderIfNotExists(context.Background(), userID, number)
+	if err != nil {
+		t.Fatalf("GetOrCreateOrderIfNotExists: %v", err)
+	}
+
+	return order
+}
+
+func credit(t *testing.T, s storage.Storage, userID string, number string, accrual int) {
+	order := createOrder(t, s, userID, number)
+
+	if err := s.UpdateOrder(context.Background(), order, accrual, entities.OrderStatusProcessed); err != nil {
+		t.Fatalf("UpdateOrder: %v", err)
+	}
+}
+
+func noLockout(int) time.Duration {
+	return 0
+}
+
+// recordLoginAttempt returns the number lockout was called with, zero when
+// the attempt was refused, and the lockout that refused it.
+func recordLoginAttempt(
+	t *testing.T,
+	s storage.Storage,
+	key string,
+	window time.Duration,
+	lockout func(int) time.Duration,
+) (int, time.Duration) {
+	t.Helper()
+
+	var attempts int
+
+	locked, err := s.RecordLoginAttempt(context.Background(), key, window, func(n int) time.Duration {
+		attempts = n
+		return lockout(n)
+	})
+	if err != nil {
+		t.Fatalf("RecordLoginAttempt: %v", err)
+	}
+
+	return attempts, locked
+}
+
+func assertBalance(t *testing.T, s storage.Storage, userID string, accrual int, withdrawn int) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	gotAccrual, err := s.GetUserAccrual(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserAccrual: %v", err)
+	}
+
+	gotWithdrawn, err := s.GetUserWithdrawn(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUserWithdrawn: %v", err)
+	}
+
+	if gotAccrual != accrual || gotWithdrawn != withdrawn {
+		t.Fatalf("balance: got %d/%d, want %d/%d", gotAccrual, gotWithdrawn, accrual, withdrawn)
+	}
+}
+
+func randomHex(t *testing.T, n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatalf("read random: %v", err)
+	}
+
+	return hex.EncodeToString(b)
+}